@@ -11,11 +11,149 @@ import (
 
 const defaultBufferSize = 3
 const defaultTimeout = 10
+const defaultCloneTimeout = 600
+const defaultWorkers = 4
+
+// Update strategies of folder
+const (
+	StrategyPull   = "pull"
+	StrategyFFOnly = "ff-only"
+	StrategyRebase = "rebase"
+	StrategyReset  = "reset"
+)
+
+// Update describes how folder is brought to the pushed commit
+type Update struct {
+	// Strategy is one of Strategy* constants. If empty folders without branch run git pull,
+	// folders with branch are fast-forwarded to the pushed commit
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// Submodules syncs and updates submodules recursively after update
+	Submodules bool `json:"submodules" yaml:"submodules"`
+	// LFS runs git lfs pull after update
+	LFS bool `json:"lfs" yaml:"lfs"`
+	// Clean runs git clean -fdx after update, CleanExclude patterns are kept
+	Clean        bool     `json:"clean" yaml:"clean"`
+	CleanExclude []string `json:"clean_exclude" yaml:"clean_exclude"`
+}
+
+// Clone describes how missing folder is cloned, folder branch is checked out if set
+type Clone struct {
+	// URL is cloned into folder which does not exist or is not a git repository
+	URL string `json:"url" yaml:"url"`
+	// Depth makes shallow clone of given number of commits
+	Depth        int  `json:"depth" yaml:"depth"`
+	SingleBranch bool `json:"single_branch" yaml:"single_branch"`
+}
+
+// Hook is a shell command run in folder before or after pull
+type Hook struct {
+	Command string `json:"command" yaml:"command"`
+	// Timeout in seconds, 60 by default
+	Timeout int `json:"timeout" yaml:"timeout"`
+	// IgnoreErrors makes failure of hook logged only, next hooks and pull are not stopped
+	IgnoreErrors bool `json:"ignore_errors" yaml:"ignore_errors"`
+	// Paths are glob patterns, e.g. "frontend/**", post pull hook runs only if matching files changed
+	Paths []string `json:"paths" yaml:"paths"`
+}
+
+// Hooks are commands run in folder before and after pull
+type Hooks struct {
+	PrePull  []Hook `json:"pre_pull" yaml:"pre_pull"`
+	PostPull []Hook `json:"post_pull" yaml:"post_pull"`
+}
+
+// Failure kinds of git commands
+const (
+	FailureTimeout  = "timeout"
+	FailureNetwork  = "network"
+	FailureLock     = "lock"
+	FailureConflict = "conflict"
+	FailureOther    = "other"
+)
+
+// Retry is a policy of repeating failed git commands of update with exponential backoff
+type Retry struct {
+	// Attempts is a number of attempts including the first one, 3 by default
+	Attempts int `json:"attempts" yaml:"attempts"`
+	// Backoff is a delay in seconds before the second attempt, doubled for every next one. 1 by default
+	Backoff float64 `json:"backoff" yaml:"backoff"`
+	// MaxBackoff limits the delay in seconds, 60 by default
+	MaxBackoff float64 `json:"max_backoff" yaml:"max_backoff"`
+	// Jitter randomizes the delay by given fraction of it, e.g. 0.2 is ±20%
+	Jitter float64 `json:"jitter" yaml:"jitter"`
+	// RetryOn are Failure* kinds to retry, timeout, network and lock by default
+	RetryOn []string `json:"retry_on" yaml:"retry_on"`
+}
+
+// Folder represents local copy of repository
+type Folder struct {
+	Path string `json:"path" yaml:"path"`
+	// Branch makes folder track only pushes into this branch, checked out to the pushed commit
+	Branch string `json:"branch" yaml:"branch"`
+	Remote string `json:"remote" yaml:"remote"`
+	// Atomic deploys each commit into Path/releases and switches Path/current symlink to it,
+	// git repository is kept in Path/repo
+	Atomic bool `json:"atomic" yaml:"atomic"`
+	// KeepReleases is a number of releases kept by atomic deploy, 5 by default
+	KeepReleases int `json:"keep_releases" yaml:"keep_releases"`
+	// Update overrides update options of repository, each option separately
+	Update `yaml:",inline"`
+	// Clone overrides clone options of repository, each option separately
+	Clone `yaml:",inline"`
+	// Hooks override hooks of repository, each list separately
+	Hooks `yaml:",inline"`
+	// Retry overrides retry policy of repository
+	Retry *Retry `json:"retry" yaml:"retry"`
+}
+
+// BasicAuth represents HTTP basic auth credentials
+type BasicAuth struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// Generic maps custom JSON webhook onto push event with JSONPath-like expressions, e.g. "$.commits[0].id"
+type Generic struct {
+	// Repo must evaluate to the repository name in config
+	Repo string `json:"repo" yaml:"repo"`
+	Ref  string `json:"ref" yaml:"ref"`
+	// Event must evaluate to push, tag, release, merge or ping, push if omitted
+	Event   string `json:"event" yaml:"event"`
+	Commit  string `json:"commit" yaml:"commit"`
+	Author  string `json:"author" yaml:"author"`
+	Message string `json:"message" yaml:"message"`
+	// TokenHeader carries the secret, X-Gitwh-Token by default
+	TokenHeader string `json:"token_header" yaml:"token_header"`
+}
 
 // Repo represents repository
 type Repo struct {
 	Secret  string   `json:"secret" yaml:"secret"`
-	Folders []string `json:"folders" yaml:"folders"`
+	Folders []Folder `json:"folders" yaml:"folders"`
+	// URLs are clone or web URLs matched against the pushed repository, repo is not matched by short name then
+	URLs []string `json:"urls" yaml:"urls"`
+	// Events trigger pull: push, tag, release, merge. Push only by default
+	Events []string `json:"events" yaml:"events"`
+	// Branches limits pulls to pushes into matching branches (glob patterns), empty means any branch
+	Branches []string `json:"branches" yaml:"branches"`
+	// BasicAuth requires hooks to carry these credentials, e.g. Azure DevOps service hooks
+	BasicAuth *BasicAuth `json:"basic_auth" yaml:"basic_auth"`
+	// Generic enables custom JSON webhooks for this repository
+	Generic *Generic `json:"generic" yaml:"generic"`
+	// AllowSHA1 accepts legacy X-Hub-Signature (HMAC-SHA1) when X-Hub-Signature-256 is absent
+	AllowSHA1 bool `json:"allow_sha1" yaml:"allow_sha1"`
+	// Debounce is a delay in seconds before pull, pushes within it result in a single pull
+	Debounce int `json:"debounce" yaml:"debounce"`
+	// Workers limits folders of repository pulled at once, only global limit applies if zero
+	Workers int `json:"workers" yaml:"workers"`
+	// Update options of repository folders
+	Update `yaml:",inline"`
+	// Clone options of repository folders
+	Clone `yaml:",inline"`
+	// Hooks run in each folder of repository
+	Hooks `yaml:",inline"`
+	// Retry makes failed updates of folders repeated, update is tried once if nil
+	Retry *Retry `json:"retry" yaml:"retry"`
 }
 
 // Config represents configuration for Webhook
@@ -24,6 +162,91 @@ type Config struct {
 	Repos      map[string]Repo `json:"repos"  yaml:"repos"`
 	BufferSize int             `json:"buffer_size" yaml:"buffer_size"`
 	Timeout    int             `json:"timeout" yaml:"timeout"`
+	// CloneTimeout in seconds limits clone of missing folders instead of Timeout
+	CloneTimeout int `json:"clone_timeout" yaml:"clone_timeout"`
+	// Workers limits folders pulled at once across all repositories
+	Workers int `json:"workers" yaml:"workers"`
+	// AdminToken enables admin API authorized by this bearer token, disabled if empty
+	AdminToken string `json:"admin_token" yaml:"admin_token"`
+	// StateDir keeps job journal, jobs unfinished on restart are replayed. Jobs are not persisted if empty
+	StateDir string `json:"state_dir" yaml:"state_dir"`
+}
+
+// UnmarshalJSON allows folder to be given as plain path string
+func (f *Folder) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = Folder{Path: path}
+		return nil
+	}
+
+	type folder Folder
+	return json.Unmarshal(data, (*folder)(f))
+}
+
+// UnmarshalYAML allows folder to be given as plain path string
+func (f *Folder) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = Folder{Path: value.Value}
+		return nil
+	}
+
+	type folder Folder
+	return value.Decode((*folder)(f))
+}
+
+// ResolveFolder returns folder with options of repository applied to it.
+// Update and clone options are merged one by one, options set on folder win, own hook lists are kept
+func (r Repo) ResolveFolder(f Folder) Folder {
+	if f.Strategy == "" {
+		f.Strategy = r.Strategy
+	}
+	f.Submodules = f.Submodules || r.Submodules
+	f.LFS = f.LFS || r.LFS
+	f.Clean = f.Clean || r.Clean
+	if f.CleanExclude == nil {
+		f.CleanExclude = r.CleanExclude
+	}
+	if f.URL == "" {
+		f.URL = r.URL
+	}
+	if f.Depth == 0 {
+		f.Depth = r.Depth
+	}
+	f.SingleBranch = f.SingleBranch || r.SingleBranch
+	if f.PrePull == nil {
+		f.PrePull = r.PrePull
+	}
+	if f.PostPull == nil {
+		f.PostPull = r.PostPull
+	}
+	if f.Retry == nil {
+		f.Retry = r.Retry
+	}
+	return f
+}
+
+// UnmarshalJSON allows hook to be given as plain command string
+func (h *Hook) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*h = Hook{Command: command}
+		return nil
+	}
+
+	type hook Hook
+	return json.Unmarshal(data, (*hook)(h))
+}
+
+// UnmarshalYAML allows hook to be given as plain command string
+func (h *Hook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*h = Hook{Command: value.Value}
+		return nil
+	}
+
+	type hook Hook
+	return value.Decode((*hook)(h))
 }
 
 type Decoder interface {
@@ -32,7 +255,7 @@ type Decoder interface {
 
 // Default returns default config without any repos, listen on port 8080
 func Default() *Config {
-	return &Config{BufferSize: defaultBufferSize, Timeout: defaultTimeout, Listen: ":8080"}
+	return &Config{BufferSize: defaultBufferSize, Timeout: defaultTimeout, CloneTimeout: defaultCloneTimeout, Workers: defaultWorkers, Listen: ":8080"}
 }
 
 // FromFile reads configurations from file
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -17,6 +18,14 @@ func TestDefault(t *testing.T) {
 		t.Errorf("Expected Timeout %d, got %d", defaultTimeout, cfg.Timeout)
 	}
 	
+	if cfg.CloneTimeout != defaultCloneTimeout {
+		t.Errorf("Expected CloneTimeout %d, got %d", defaultCloneTimeout, cfg.CloneTimeout)
+	}
+	
+	if cfg.Workers != defaultWorkers {
+		t.Errorf("Expected Workers %d, got %d", defaultWorkers, cfg.Workers)
+	}
+	
 	if cfg.Listen != ":8080" {
 		t.Errorf("Expected Listen :8080, got %s", cfg.Listen)
 	}
@@ -73,7 +82,7 @@ func TestFromFileJSON(t *testing.T) {
 		t.Errorf("Expected Secret test-secret, got %s", repo.Secret)
 	}
 	
-	if len(repo.Folders) != 1 || repo.Folders[0] != "/path/to/repo" {
+	if len(repo.Folders) != 1 || repo.Folders[0].Path != "/path/to/repo" {
 		t.Errorf("Expected Folders [/path/to/repo], got %v", repo.Folders)
 	}
 }
@@ -125,7 +134,7 @@ repos:
 	}
 	
 	expectedFolders := []string{"/repo1", "/repo2"}
-	if len(repo.Folders) != 2 || repo.Folders[0] != expectedFolders[0] || repo.Folders[1] != expectedFolders[1] {
+	if len(repo.Folders) != 2 || repo.Folders[0].Path != expectedFolders[0] || repo.Folders[1].Path != expectedFolders[1] {
 		t.Errorf("Expected Folders %v, got %v", expectedFolders, repo.Folders)
 	}
 }
@@ -180,4 +189,162 @@ func TestFromFileInvalidYAML(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for invalid YAML")
 	}
-}
\ No newline at end of file
+}
+func TestFromFileFolderObjects(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	yamlFile := filepath.Join(tmpDir, "folders.yaml")
+	yamlContent := `repos:
+  my-repo:
+    folders:
+      - "/plain"
+      - path: "/staging"
+        branch: "develop"
+      - path: "/production"
+        branch: "main"
+        remote: "upstream"
+`
+	jsonFile := filepath.Join(tmpDir, "folders.json")
+	jsonContent := `{"repos": {"my-repo": {"folders": [
+		"/plain",
+		{"path": "/staging", "branch": "develop"},
+		{"path": "/production", "branch": "main", "remote": "upstream"}
+	]}}}`
+
+	expected := []Folder{
+		{Path: "/plain"},
+		{Path: "/staging", Branch: "develop"},
+		{Path: "/production", Branch: "main", Remote: "upstream"},
+	}
+
+	for file, content := range map[string]string{yamlFile: yamlContent, jsonFile: jsonContent} {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test config file: %v", err)
+		}
+
+		cfg, err := FromFile(file)
+		if err != nil {
+			t.Fatalf("FromFile(%s) failed: %v", file, err)
+		}
+
+		folders := cfg.Repos["my-repo"].Folders
+		if len(folders) != len(expected) {
+			t.Fatalf("%s: expected %d folders, got %d", file, len(expected), len(folders))
+		}
+
+		for i := range expected {
+			if !reflect.DeepEqual(folders[i], expected[i]) {
+				t.Errorf("%s: expected folder %+v, got %+v", file, expected[i], folders[i])
+			}
+		}
+	}
+}
+
+func TestResolveFolder(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	yamlFile := filepath.Join(tmpDir, "strategy.yaml")
+	yamlContent := `repos:
+  my-repo:
+    strategy: reset
+    clean: true
+    clean_exclude: [".env"]
+    folders:
+      - "/production"
+      - path: "/staging"
+        strategy: rebase
+        clean_exclude: ["storage/"]
+      - path: "/preview"
+        submodules: true
+        url: "https://example.com/app.git"
+`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	cfg, err := FromFile(yamlFile)
+	if err != nil {
+		t.Fatalf("FromFile failed: %v", err)
+	}
+
+	repo := cfg.Repos["my-repo"]
+	expected := []Folder{
+		{Path: "/production", Update: Update{Strategy: StrategyReset, Clean: true, CleanExclude: []string{".env"}}},
+		{Path: "/staging", Update: Update{Strategy: StrategyRebase, Clean: true, CleanExclude: []string{"storage/"}}},
+		{Path: "/preview", Update: Update{Strategy: StrategyReset, Submodules: true, Clean: true, CleanExclude: []string{".env"}},
+			Clone: Clone{URL: "https://example.com/app.git"}},
+	}
+
+	for i, folder := range repo.Folders {
+		if resolved := repo.ResolveFolder(folder); !reflect.DeepEqual(resolved, expected[i]) {
+			t.Errorf("Expected folder %+v, got %+v", expected[i], resolved)
+		}
+	}
+}
+
+func TestFromFileHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	yamlFile := filepath.Join(tmpDir, "hooks.yaml")
+	yamlContent := `repos:
+  my-repo:
+    pre_pull: ["php artisan down"]
+    post_pull:
+      - composer install
+      - command: npm ci && npm run build
+        timeout: 600
+        ignore_errors: true
+    folders:
+      - "/production"
+      - path: "/staging"
+        post_pull: []
+`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	cfg, err := FromFile(yamlFile)
+	if err != nil {
+		t.Fatalf("FromFile failed: %v", err)
+	}
+
+	repo := cfg.Repos["my-repo"]
+	expected := []Hook{{Command: "composer install"}, {Command: "npm ci && npm run build", Timeout: 600, IgnoreErrors: true}}
+
+	production := repo.ResolveFolder(repo.Folders[0])
+	if !reflect.DeepEqual(production.PostPull, expected) || len(production.PrePull) != 1 {
+		t.Errorf("Expected hooks of repository, got %+v", production.Hooks)
+	}
+
+	staging := repo.ResolveFolder(repo.Folders[1])
+	if len(staging.PostPull) != 0 || len(staging.PrePull) != 1 {
+		t.Errorf("Expected post_pull hooks to be overridden, got %+v", staging.Hooks)
+	}
+}
+
+func TestFromFileRetry(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	jsonFile := filepath.Join(tmpDir, "retry.json")
+	jsonContent := `{"repos": {"my-repo": {
+		"retry": {"attempts": 5, "backoff": 0.5, "max_backoff": 30, "jitter": 0.2, "retry_on": ["network", "timeout"]},
+		"folders": ["/production", {"path": "/staging", "retry": {"attempts": 1}}]
+	}}}`
+	if err := os.WriteFile(jsonFile, []byte(jsonContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	cfg, err := FromFile(jsonFile)
+	if err != nil {
+		t.Fatalf("FromFile failed: %v", err)
+	}
+
+	repo := cfg.Repos["my-repo"]
+	expected := &Retry{Attempts: 5, Backoff: 0.5, MaxBackoff: 30, Jitter: 0.2, RetryOn: []string{FailureNetwork, FailureTimeout}}
+	if production := repo.ResolveFolder(repo.Folders[0]); !reflect.DeepEqual(production.Retry, expected) {
+		t.Errorf("Expected retry policy %+v, got %+v", expected, production.Retry)
+	}
+	if staging := repo.ResolveFolder(repo.Folders[1]); staging.Retry == nil || staging.Retry.Attempts != 1 {
+		t.Errorf("Expected retry policy of folder, got %+v", staging.Retry)
+	}
+}
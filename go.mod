@@ -3,6 +3,6 @@ module gitwh
 go 1.20
 
 require (
-	github.com/go-chi/chi/v5 v5.0.12 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/go-chi/chi/v5 v5.0.12
+	gopkg.in/yaml.v3 v3.0.1
 )
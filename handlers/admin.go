@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"gitwh/config"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitwh/puller"
+)
+
+// errRollbackTarget reports rollback target which can not be a commit or release name
+var errRollbackTarget = errors.New("invalid rollback target")
+
+// checkAdminToken validates bearer token of admin request
+func (h *handler) checkAdminToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// RollbackJob returns job rolling folders of repository back. Folder limits rollback to one folder,
+// target is a commit or release name, the previous deploy if empty
+func RollbackJob(name string, repo config.Repo, folder string, target string) (*puller.Job, error) {
+	if target == "" {
+		target = puller.RollbackPrevious
+	}
+	if strings.HasPrefix(target, "-") {
+		return nil, fmt.Errorf("%w %s", errRollbackTarget, target)
+	}
+
+	job := &puller.Job{Repo: name, Workers: repo.Workers, Rollback: target}
+	for _, f := range repo.Folders {
+		if folder == "" || f.Path == folder {
+			job.Folders = append(job.Folders, repo.ResolveFolder(f))
+		}
+	}
+
+	if len(job.Folders) == 0 {
+		return nil, fmt.Errorf("folder %s of %s not found", folder, name)
+	}
+	return job, nil
+}
+
+// rollback queues rollback of repository folders: POST /admin/rollback/{repo}?folder=<path>&to=<commit or release>
+func (h *handler) rollback(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAdminToken(r) {
+		fmt.Printf("[%s] Admin request is not authorized\n", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name, err := url.PathUnescape(chi.URLParam(r, "repo"))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	repo, ok := h.repos[name]
+	if !ok {
+		fmt.Printf("[%s] Rollback : repository %s not supported\n", r.RemoteAddr, name)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	job, err := RollbackJob(name, repo, r.URL.Query().Get("folder"), r.URL.Query().Get("to"))
+	if errors.Is(err, errRollbackTarget) {
+		fmt.Printf("[%s] Rollback : %v\n", r.RemoteAddr, err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Printf("[%s] Rollback : %v\n", r.RemoteAddr, err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	fmt.Printf("[%s] Rollback of %s to %s\n", r.RemoteAddr, name, job.Rollback)
+	h.enqueue(w, r, job)
+}
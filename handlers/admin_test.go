@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gitwh/config"
+	"gitwh/puller"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminRollback(t *testing.T) {
+	repos := map[string]config.Repo{
+		"acme/app": {
+			Folders: []config.Folder{{Path: "/srv/production"}, {Path: "/srv/staging"}},
+			Update:  config.Update{Strategy: config.StrategyReset},
+		},
+	}
+
+	pulled := make(chanPuller, 1)
+	handler := New(repos, 1, 1, pulled, nil, "admin-token")
+
+	req := httptest.NewRequest("POST", "/admin/rollback/acme%2Fapp?folder=/srv/staging&to=abc1234", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/rollback/acme%2Fapp?folder=/srv/staging&to=abc1234", nil)
+	req.Header.Set("Authorization", "admin-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without Bearer prefix, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/rollback/acme%2Fapp?folder=/srv/staging&to=--upload-pack", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for option-like target, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/rollback/acme%2Fapp?folder=/srv/staging&to=abc1234", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp jobResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.ID == "" {
+		t.Errorf("Expected job id in response, got %q, %v", w.Body.String(), err)
+	}
+
+	select {
+	case job := <-pulled:
+		if job.Rollback != "abc1234" || job.Repo != "acme/app" || len(job.Folders) != 1 || job.Folders[0].Path != "/srv/staging" {
+			t.Errorf("Expected rollback of staging to abc1234, got %+v", job)
+		}
+		if job.Folders[0].Strategy != config.StrategyReset {
+			t.Errorf("Expected folder options of repository, got %+v", job.Folders[0])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected rollback job to be pulled")
+	}
+}
+
+func TestAdminRollbackNotFound(t *testing.T) {
+	repos := map[string]config.Repo{"app": {Folders: []config.Folder{{Path: "/srv/app"}}}}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "admin-token")
+
+	for _, target := range []string{"/admin/rollback/other", "/admin/rollback/app?folder=/srv/other"} {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	repos := map[string]config.Repo{"app": {Folders: []config.Folder{{Path: "/srv/app"}}}}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	req := httptest.NewRequest("POST", "/admin/rollback/app", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code == http.StatusAccepted {
+		t.Error("Expected admin API to be disabled without admin token")
+	}
+}
+
+func TestRollbackJob(t *testing.T) {
+	repo := config.Repo{Workers: 2, Folders: []config.Folder{{Path: "/a"}, {Path: "/b"}}}
+
+	job, err := RollbackJob("app", repo, "", "")
+	if err != nil {
+		t.Fatalf("Expected job, got %v", err)
+	}
+	if job.Rollback != puller.RollbackPrevious || len(job.Folders) != 2 || job.Workers != 2 {
+		t.Errorf("Expected rollback of all folders to previous deploy, got %+v", job)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"net/http"
+)
+
+const (
+	azurePush  = "git.push"
+	azureMerge = "git.pullrequest.merged"
+)
+
+type azureEnvelope struct {
+	EventType   string `json:"eventType"`
+	PublisherID string `json:"publisherId"`
+}
+
+type azureRepository struct {
+	Name      string `json:"name"`
+	RemoteURL string `json:"remoteUrl"`
+	SSHURL    string `json:"sshUrl"`
+	Project   struct {
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+// fill sets repository fields of payload, full name is "project/name"
+func (r azureRepository) fill(p *Payload) {
+	p.Repo = r.Name
+	p.FullName = r.Project.Name + "/" + r.Name
+	p.URLs = []string{r.RemoteURL, r.SSHURL}
+}
+
+type azureMergePayload struct {
+	Resource struct {
+		Title           string          `json:"title"`
+		MergeStatus     string          `json:"mergeStatus"`
+		TargetRefName   string          `json:"targetRefName"`
+		Repository      azureRepository `json:"repository"`
+		LastMergeCommit struct {
+			CommitID string `json:"commitId"`
+		} `json:"lastMergeCommit"`
+		CreatedBy struct {
+			DisplayName string `json:"displayName"`
+			UniqueName  string `json:"uniqueName"`
+		} `json:"createdBy"`
+	} `json:"resource"`
+}
+
+type azurePayload struct {
+	Resource struct {
+		Commits []struct {
+			CommitID string `json:"commitId"`
+			Comment  string `json:"comment"`
+		} `json:"commits"`
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		Repository azureRepository `json:"repository"`
+		PushedBy   struct {
+			DisplayName string `json:"displayName"`
+			UniqueName  string `json:"uniqueName"`
+		} `json:"pushedBy"`
+	} `json:"resource"`
+}
+
+type azureProvider struct{}
+
+func (azureProvider) Name() string {
+	return "azure"
+}
+
+// Detect peeks into JSON body as service hooks carry no provider headers
+func (azureProvider) Detect(r *http.Request, body []byte) bool {
+	if !isJSON(r) {
+		return false
+	}
+
+	env := &azureEnvelope{}
+	return json.Unmarshal(body, env) == nil && env.PublisherID == "tfs"
+}
+
+func (azureProvider) Parse(_ *http.Request, body []byte) (*Payload, error) {
+	env := &azureEnvelope{}
+	if err := json.Unmarshal(body, env); err != nil {
+		return nil, err
+	}
+
+	switch env.EventType {
+	case azurePush:
+		return azurePushed(body)
+	case azureMerge:
+		return azureMerged(body)
+	default:
+		return nil, fmt.Errorf("%w: azure event %s", errIgnored, env.EventType)
+	}
+}
+
+func azurePushed(body []byte) (*Payload, error) {
+	pl := &azurePayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	res := pl.Resource
+	if len(res.RefUpdates) == 0 {
+		return nil, fmt.Errorf("no ref updates in push %v", res)
+	}
+
+	if res.RefUpdates[0].NewObjectID == zeroCommit {
+		return nil, fmt.Errorf("%w: ref %s of %s/%s deleted", errIgnored, res.RefUpdates[0].Name, res.Repository.Project.Name, res.Repository.Name)
+	}
+
+	p := Payload{
+		Event:    refEvent(res.RefUpdates[0].Name),
+		Name:     res.PushedBy.DisplayName,
+		Email:    res.PushedBy.UniqueName,
+		CommitId: res.RefUpdates[0].NewObjectID,
+		Ref:      res.RefUpdates[0].Name,
+	}
+	res.Repository.fill(&p)
+
+	for _, commit := range res.Commits {
+		if commit.CommitID == p.CommitId {
+			p.Message = commit.Comment
+		}
+	}
+
+	return &p, nil
+}
+
+// azureMerged reports successfully merged pull requests only
+func azureMerged(body []byte) (*Payload, error) {
+	pl := &azureMergePayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	res := pl.Resource
+	if res.MergeStatus != "succeeded" {
+		return nil, fmt.Errorf("%w: pull request %s merge %s", errIgnored, res.Title, res.MergeStatus)
+	}
+
+	p := Payload{
+		Event:    EventMerge,
+		Name:     res.CreatedBy.DisplayName,
+		Email:    res.CreatedBy.UniqueName,
+		CommitId: res.LastMergeCommit.CommitID,
+		Message:  res.Title,
+		Ref:      res.TargetRefName,
+	}
+	res.Repository.fill(&p)
+	return &p, nil
+}
+
+// Verify accepts no header secret, service hooks authenticate by basic auth only
+func (azureProvider) Verify(_ *http.Request, _ []byte, repo config.Repo) error {
+	if repo.Secret != "" {
+		return fmt.Errorf("azure devops hooks carry no secret, use basic_auth instead")
+	}
+	return nil
+}
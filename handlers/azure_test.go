@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"gitwh/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const azurePushBody = `{
+	"eventType": "git.push",
+	"publisherId": "tfs",
+	"resource": {
+		"commits": [{"commitId": "abc1234", "comment": "azure commit", "author": {"name": "Azure User"}}],
+		"refUpdates": [{"name": "refs/heads/main", "oldObjectId": "000111", "newObjectId": "abc1234"}],
+		"repository": {"name": "azure-repo", "project": {"name": "Project"}},
+		"pushedBy": {"displayName": "Azure User", "uniqueName": "azure@example.com"}
+	}
+}`
+
+func azureRequest(body string) *http.Request {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return req
+}
+
+func TestAzurePayload(t *testing.T) {
+	result, err := parseRequest(azureRequest(azurePushBody))
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "azure-repo" {
+		t.Errorf("Expected Repo azure-repo, got %s", result.Repo)
+	}
+
+	if result.Ref != "refs/heads/main" || result.CommitId != "abc1234" {
+		t.Errorf("Expected refs/heads/main at abc1234, got %s at %s", result.Ref, result.CommitId)
+	}
+
+	if result.Message != "azure commit" {
+		t.Errorf("Expected Message 'azure commit', got %s", result.Message)
+	}
+
+	if result.Name != "Azure User" || result.Email != "azure@example.com" {
+		t.Errorf("Expected Azure User (azure@example.com), got %s (%s)", result.Name, result.Email)
+	}
+}
+
+func TestAzurePayloadDeletedBranch(t *testing.T) {
+	body := strings.Replace(azurePushBody, `"newObjectId": "abc1234"`, `"newObjectId": "0000000000000000000000000000000000000000"`, 1)
+
+	if _, err := parseRequest(azureRequest(body)); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected branch deletion to be ignored, got %v", err)
+	}
+}
+
+func TestHandleAzureBasicAuth(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["azure-repo"] = config.Repo{
+		Folders:   []config.Folder{{Path: "/path/to/repo"}},
+		BasicAuth: &config.BasicAuth{Username: "hook", Password: "pass"},
+	}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	tests := []struct {
+		username string
+		password string
+		expected int
+	}{
+		{"hook", "pass", http.StatusAccepted},
+		{"hook", "wrong", http.StatusBadRequest},
+		{"other", "pass", http.StatusBadRequest},
+		{"", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := azureRequest(azurePushBody)
+		if tt.username != "" {
+			req.SetBasicAuth(tt.username, tt.password)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.expected {
+			t.Errorf("Credentials %s:%s: expected status %d, got %d", tt.username, tt.password, tt.expected, w.Code)
+		}
+	}
+}
+
+func TestHandleAzureNonPushEvent(t *testing.T) {
+	handler := New(make(map[string]config.Repo), 1, 1, &mockPuller{}, nil, "")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, azureRequest(`{"eventType": "workitem.created", "publisherId": "tfs"}`))
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+}
+
+func TestAzureMergedPayload(t *testing.T) {
+	body := `{"eventType": "git.pullrequest.merged", "publisherId": "tfs", "resource": {"title": "Feature",
+		"mergeStatus": "succeeded", "targetRefName": "refs/heads/main", "lastMergeCommit": {"commitId": "abc1234"},
+		"repository": {"name": "azure-repo", "project": {"name": "Project"}}}}`
+
+	result, err := parseRequest(azureRequest(body))
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Event != EventMerge || result.Ref != "refs/heads/main" || result.CommitId != "abc1234" {
+		t.Errorf("Expected merge into refs/heads/main at abc1234, got %s into %s at %s", result.Event, result.Ref, result.CommitId)
+	}
+
+	if result.FullName != "Project/azure-repo" {
+		t.Errorf("Expected FullName Project/azure-repo, got %s", result.FullName)
+	}
+
+	body = strings.Replace(body, "succeeded", "conflicts", 1)
+	if _, err := parseRequest(azureRequest(body)); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected failed merge to be ignored, got %v", err)
+	}
+}
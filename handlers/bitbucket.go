@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+const (
+	bitbucketCloudPush   = "repo:push"
+	bitbucketCloudMerge  = "pullrequest:fulfilled"
+	bitbucketServerPush  = "repo:refs_changed"
+	bitbucketServerMerge = "pr:merged"
+	bitbucketServerPing  = "diagnostics:ping"
+	bitbucketCloudTag    = "tag"
+)
+
+type bitbucketCloudRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Links    struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketServerRepository struct {
+	Slug    string `json:"slug"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links struct {
+		Clone []struct {
+			Href string `json:"href"`
+		} `json:"clone"`
+	} `json:"links"`
+}
+
+type bitbucketCloudPayload struct {
+	Actor struct {
+		DisplayName string `json:"display_name"`
+	} `json:"actor"`
+	Repository bitbucketCloudRepository `json:"repository"`
+	Push       struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash    string `json:"hash"`
+					Message string `json:"message"`
+					Author  struct {
+						Raw string `json:"raw"`
+					} `json:"author"`
+				} `json:"target"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+type bitbucketServerPayload struct {
+	Actor struct {
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"actor"`
+	Repository bitbucketServerRepository `json:"repository"`
+	Changes    []struct {
+		RefID  string `json:"refId"`
+		ToHash string `json:"toHash"`
+		Type   string `json:"type"`
+	} `json:"changes"`
+}
+
+type bitbucketCloudMergePayload struct {
+	Actor struct {
+		DisplayName string `json:"display_name"`
+	} `json:"actor"`
+	Repository  bitbucketCloudRepository `json:"repository"`
+	PullRequest struct {
+		Title       string `json:"title"`
+		Destination struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"destination"`
+		MergeCommit struct {
+			Hash string `json:"hash"`
+		} `json:"merge_commit"`
+	} `json:"pullrequest"`
+}
+
+type bitbucketServerMergePayload struct {
+	Actor struct {
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"actor"`
+	PullRequest struct {
+		Title string `json:"title"`
+		ToRef struct {
+			ID         string                    `json:"id"`
+			Repository bitbucketServerRepository `json:"repository"`
+		} `json:"toRef"`
+		Properties struct {
+			MergeCommit struct {
+				ID string `json:"id"`
+			} `json:"mergeCommit"`
+		} `json:"properties"`
+	} `json:"pullRequest"`
+}
+
+// fill sets repository fields of payload
+func (r bitbucketCloudRepository) fill(p *Payload) {
+	p.Repo = r.Name
+	p.FullName = r.FullName
+	p.URLs = []string{r.Links.HTML.Href}
+}
+
+// fill sets repository fields of payload, full name is "PROJECT/slug"
+func (r bitbucketServerRepository) fill(p *Payload) {
+	p.Repo = r.Slug
+	p.FullName = r.Project.Key + "/" + r.Slug
+	for _, link := range r.Links.Clone {
+		p.URLs = append(p.URLs, link.Href)
+	}
+}
+
+// bitbucketEvent returns Bitbucket event key, empty for other providers
+func bitbucketEvent(r *http.Request) string {
+	return r.Header.Get("X-Event-Key")
+}
+
+type bitbucketProvider struct{}
+
+func (bitbucketProvider) Name() string {
+	return "bitbucket"
+}
+
+func (bitbucketProvider) Detect(r *http.Request, _ []byte) bool {
+	return bitbucketEvent(r) != ""
+}
+
+func (bitbucketProvider) Parse(r *http.Request, body []byte) (*Payload, error) {
+	switch event := bitbucketEvent(r); event {
+	case bitbucketCloudPush:
+		return bitbucketCloud(body)
+	case bitbucketServerPush:
+		return bitbucketServer(body)
+	case bitbucketCloudMerge:
+		return bitbucketCloudMerged(body)
+	case bitbucketServerMerge:
+		return bitbucketServerMerged(body)
+	case bitbucketServerPing:
+		return &Payload{Event: EventPing}, nil
+	default:
+		return nil, fmt.Errorf("%w: bitbucket event %s", errIgnored, event)
+	}
+}
+
+// Verify checks X-Hub-Signature sent by Bitbucket Server / Data Center and Cloud, prefixed by hash name
+func (bitbucketProvider) Verify(r *http.Request, body []byte, repo config.Repo) error {
+	var sha256Signature, sha1Signature string
+
+	signature := r.Header.Get("X-Hub-Signature")
+	switch {
+	case strings.HasPrefix(signature, "sha256="):
+		sha256Signature = strings.TrimPrefix(signature, "sha256=")
+	case strings.HasPrefix(signature, "sha1="):
+		sha1Signature = strings.TrimPrefix(signature, "sha1=")
+	}
+
+	return verifySignature(repo, body, sha256Signature, sha1Signature)
+}
+
+// bitbucketCloud parses repo:push payload of Bitbucket Cloud, first updated ref is used
+func bitbucketCloud(body []byte) (*Payload, error) {
+	pl := &bitbucketCloudPayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	if len(pl.Push.Changes) > 1 {
+		log.Printf("Multi Change in one hook (len: %d)", len(pl.Push.Changes))
+	}
+
+	deleted := false
+	for _, change := range pl.Push.Changes {
+		if change.New == nil {
+			deleted = true
+			continue
+		}
+
+		p := Payload{
+			Event:    EventPush,
+			Name:     pl.Actor.DisplayName,
+			CommitId: change.New.Target.Hash,
+			Message:  change.New.Target.Message,
+			Ref:      "refs/heads/" + change.New.Name,
+		}
+		pl.Repository.fill(&p)
+
+		if change.New.Type == bitbucketCloudTag {
+			p.Event = EventTag
+			p.Ref = "refs/tags/" + change.New.Name
+		}
+
+		if author, err := mail.ParseAddress(change.New.Target.Author.Raw); err == nil {
+			p.Email = author.Address
+		}
+		return &p, nil
+	}
+
+	if deleted {
+		return nil, fmt.Errorf("%w: refs of %s deleted", errIgnored, pl.Repository.FullName)
+	}
+	return nil, fmt.Errorf("no updated refs in push %v", pl.Push.Changes)
+}
+
+// bitbucketServer parses repo:refs_changed payload of Bitbucket Server / Data Center, first updated ref is used
+func bitbucketServer(body []byte) (*Payload, error) {
+	pl := &bitbucketServerPayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	if len(pl.Changes) > 1 {
+		log.Printf("Multi Change in one hook (len: %d)", len(pl.Changes))
+	}
+
+	deleted := false
+	for _, change := range pl.Changes {
+		if change.Type == "DELETE" {
+			deleted = true
+			continue
+		}
+
+		p := Payload{
+			Event:    refEvent(change.RefID),
+			Name:     pl.Actor.DisplayName,
+			Email:    pl.Actor.EmailAddress,
+			CommitId: change.ToHash,
+			Ref:      change.RefID,
+		}
+		pl.Repository.fill(&p)
+		return &p, nil
+	}
+
+	if deleted {
+		return nil, fmt.Errorf("%w: refs of %s deleted", errIgnored, pl.Repository.Project.Key+"/"+pl.Repository.Slug)
+	}
+	return nil, fmt.Errorf("no updated refs in changes %v", pl.Changes)
+}
+
+// bitbucketCloudMerged parses pullrequest:fulfilled payload of Bitbucket Cloud
+func bitbucketCloudMerged(body []byte) (*Payload, error) {
+	pl := &bitbucketCloudMergePayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	p := Payload{
+		Event:    EventMerge,
+		Name:     pl.Actor.DisplayName,
+		CommitId: pl.PullRequest.MergeCommit.Hash,
+		Message:  pl.PullRequest.Title,
+		Ref:      "refs/heads/" + pl.PullRequest.Destination.Branch.Name,
+	}
+	pl.Repository.fill(&p)
+	return &p, nil
+}
+
+// bitbucketServerMerged parses pr:merged payload of Bitbucket Server / Data Center
+func bitbucketServerMerged(body []byte) (*Payload, error) {
+	pl := &bitbucketServerMergePayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	p := Payload{
+		Event:    EventMerge,
+		Name:     pl.Actor.DisplayName,
+		Email:    pl.Actor.EmailAddress,
+		CommitId: pl.PullRequest.Properties.MergeCommit.ID,
+		Message:  pl.PullRequest.Title,
+		Ref:      pl.PullRequest.ToRef.ID,
+	}
+	pl.PullRequest.ToRef.Repository.fill(&p)
+	return &p, nil
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"gitwh/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const bitbucketCloudBody = `{
+	"actor": {"display_name": "Cloud User"},
+	"repository": {"name": "cloud-repo", "full_name": "team/cloud-repo"},
+	"push": {"changes": [
+		{"new": null},
+		{"new": {"type": "branch", "name": "main", "target": {
+			"hash": "abc1234", "message": "cloud commit", "author": {"raw": "Cloud User <cloud@example.com>"}
+		}}}
+	]}
+}`
+
+const bitbucketServerBody = `{
+	"eventKey": "repo:refs_changed",
+	"actor": {"name": "admin", "emailAddress": "admin@example.com", "displayName": "Administrator"},
+	"repository": {"slug": "server-repo", "name": "Server Repo", "project": {"key": "PROJ"}},
+	"changes": [
+		{"refId": "refs/heads/old", "toHash": "0000000000000000000000000000000000000000", "type": "DELETE"},
+		{"refId": "refs/heads/main", "fromHash": "aaa1111", "toHash": "def4567", "type": "UPDATE"}
+	]
+}`
+
+func TestBitbucketCloudPayload(t *testing.T) {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(bitbucketCloudBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Key", "repo:push")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "cloud-repo" {
+		t.Errorf("Expected Repo cloud-repo, got %s", result.Repo)
+	}
+
+	if result.Ref != "refs/heads/main" {
+		t.Errorf("Expected Ref refs/heads/main, got %s", result.Ref)
+	}
+
+	if result.CommitId != "abc1234" || result.Message != "cloud commit" {
+		t.Errorf("Expected commit abc1234 'cloud commit', got %s '%s'", result.CommitId, result.Message)
+	}
+
+	if result.Name != "Cloud User" || result.Email != "cloud@example.com" {
+		t.Errorf("Expected Cloud User (cloud@example.com), got %s (%s)", result.Name, result.Email)
+	}
+}
+
+func TestBitbucketServerPayload(t *testing.T) {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(bitbucketServerBody))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Event-Key", "repo:refs_changed")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "server-repo" {
+		t.Errorf("Expected Repo server-repo, got %s", result.Repo)
+	}
+
+	if result.Ref != "refs/heads/main" || result.CommitId != "def4567" {
+		t.Errorf("Expected refs/heads/main at def4567, got %s at %s", result.Ref, result.CommitId)
+	}
+
+	if result.Name != "Administrator" || result.Email != "admin@example.com" {
+		t.Errorf("Expected Administrator (admin@example.com), got %s (%s)", result.Name, result.Email)
+	}
+}
+
+func TestHandleBitbucketServerSignature(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["server-repo"] = config.Repo{
+		Secret:  "bb-secret",
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
+	}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	body := []byte(bitbucketServerBody)
+	for secret, expected := range map[string]int{"bb-secret": http.StatusAccepted, "wrong-secret": http.StatusBadRequest} {
+		req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Event-Key", "repo:refs_changed")
+		req.Header.Set("X-Hub-Signature", signBody(secret, body))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Signed with %s: expected status %d, got %d", secret, expected, w.Code)
+		}
+	}
+}
+
+func TestBitbucketNoUpdatedRefs(t *testing.T) {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{"push": {"changes": []}}`))
+	req.Header.Set("X-Event-Key", "repo:push")
+
+	if _, err := parseRequest(req); err == nil || errors.Is(err, errIgnored) {
+		t.Errorf("Expected error for push without updated refs, got %v", err)
+	}
+}
+
+func TestBitbucketDeletedRefs(t *testing.T) {
+	tests := []struct {
+		event string
+		body  string
+	}{
+		{"repo:push", `{"push": {"changes": [{"new": null}]}}`},
+		{"repo:refs_changed", `{"changes": [{"refId": "refs/heads/old", "toHash": "0000000000000000000000000000000000000000", "type": "DELETE"}]}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(tt.body))
+		req.Header.Set("X-Event-Key", tt.event)
+
+		if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+			t.Errorf("%s: expected deletion to be ignored, got %v", tt.event, err)
+		}
+	}
+}
+
+func TestBitbucketEvents(t *testing.T) {
+	tests := []struct {
+		event    string
+		body     string
+		expected Payload
+	}{
+		{
+			"pullrequest:fulfilled",
+			`{"actor": {"display_name": "Merger"}, "repository": {"name": "cloud-repo"}, "pullrequest": {"title": "Feature",
+				"destination": {"branch": {"name": "main"}}, "merge_commit": {"hash": "abc1234"}}}`,
+			Payload{Event: EventMerge, Ref: "refs/heads/main", CommitId: "abc1234", Repo: "cloud-repo"},
+		},
+		{
+			"pr:merged",
+			`{"actor": {"displayName": "Merger"}, "pullRequest": {"title": "Feature", "toRef": {"id": "refs/heads/main",
+				"repository": {"slug": "server-repo", "project": {"key": "PROJ"}}}, "properties": {"mergeCommit": {"id": "def4567"}}}}`,
+			Payload{Event: EventMerge, Ref: "refs/heads/main", CommitId: "def4567", Repo: "server-repo"},
+		},
+		{
+			"repo:push",
+			`{"repository": {"name": "cloud-repo"}, "push": {"changes": [{"new": {"type": "tag", "name": "v1.0", "target": {"hash": "fed3210"}}}]}}`,
+			Payload{Event: EventTag, Ref: "refs/tags/v1.0", CommitId: "fed3210", Repo: "cloud-repo"},
+		},
+		{
+			"diagnostics:ping",
+			`{}`,
+			Payload{Event: EventPing},
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(tt.body))
+		req.Header.Set("X-Event-Key", tt.event)
+
+		result, err := parseRequest(req)
+		if err != nil {
+			t.Fatalf("%s: parseRequest failed: %v", tt.event, err)
+		}
+
+		if result.Event != tt.expected.Event || result.Ref != tt.expected.Ref ||
+			result.CommitId != tt.expected.CommitId || result.Repo != tt.expected.Repo {
+			t.Errorf("%s: expected %+v, got %+v", tt.event, tt.expected, *result)
+		}
+	}
+}
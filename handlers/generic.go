@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultTokenHeader = "X-Gitwh-Token"
+
+// genericProvider maps arbitrary JSON onto Payload with expressions given in repository config
+type genericProvider struct {
+	repos repoMap
+}
+
+func newGenericProvider(repos repoMap) *genericProvider {
+	generic := make(repoMap)
+	for name, repo := range repos {
+		if repo.Generic != nil {
+			generic[name] = repo
+		}
+	}
+	return &genericProvider{repos: generic}
+}
+
+func (p *genericProvider) Name() string {
+	return "generic"
+}
+
+// match returns name and mapping of repository whose repo expression evaluates to its own name
+func (p *genericProvider) match(body []byte) (string, *config.Generic, interface{}) {
+	if len(p.repos) == 0 {
+		return "", nil, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", nil, nil
+	}
+
+	names := make([]string, 0, len(p.repos))
+	for name := range p.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mapping := p.repos[name].Generic
+		if value, _ := jsonPath(doc, mapping.Repo); value == name {
+			return name, mapping, doc
+		}
+	}
+	return "", nil, nil
+}
+
+func (p *genericProvider) Detect(_ *http.Request, body []byte) bool {
+	name, _, _ := p.match(body)
+	return name != ""
+}
+
+func (p *genericProvider) Parse(_ *http.Request, body []byte) (*Payload, error) {
+	name, mapping, doc := p.match(body)
+	if name == "" {
+		return nil, fmt.Errorf("payload does not match any generic repository")
+	}
+
+	pl := Payload{Event: EventPush, Repo: name, FullName: name}
+	fields := []struct {
+		expr  string
+		field *string
+	}{
+		{mapping.Event, &pl.Event},
+		{mapping.Ref, &pl.Ref},
+		{mapping.Commit, &pl.CommitId},
+		{mapping.Author, &pl.Name},
+		{mapping.Message, &pl.Message},
+	}
+
+	for _, f := range fields {
+		if f.expr == "" {
+			continue
+		}
+		value, ok := jsonPath(doc, f.expr)
+		if !ok {
+			return nil, fmt.Errorf("expression %s not found in payload", f.expr)
+		}
+		*f.field = value
+	}
+
+	return &pl, nil
+}
+
+func (p *genericProvider) Verify(r *http.Request, _ []byte, repo config.Repo) error {
+	header := repo.Generic.TokenHeader
+	if header == "" {
+		header = defaultTokenHeader
+	}
+	return verifyToken(repo, r.Header.Get(header))
+}
+
+// jsonPath evaluates JSONPath-like expression, e.g. "$.commits[0].id" or "repository.name".
+// Negative index counts from the end of array, the result is converted to string
+func jsonPath(doc interface{}, expr string) (string, bool) {
+	expr = strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	expr = strings.ReplaceAll(expr, "[", ".[")
+
+	value := doc
+	for _, key := range strings.Split(expr, ".") {
+		if key == "" {
+			continue
+		}
+
+		if strings.HasPrefix(key, "[") && strings.HasSuffix(key, "]") {
+			arr, ok := value.([]interface{})
+			if !ok {
+				return "", false
+			}
+			index, err := strconv.Atoi(key[1 : len(key)-1])
+			if err != nil {
+				return "", false
+			}
+			if index < 0 {
+				index += len(arr)
+			}
+			if index < 0 || index >= len(arr) {
+				return "", false
+			}
+			value = arr[index]
+			continue
+		}
+
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "", true
+	case map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
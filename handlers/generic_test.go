@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gitwh/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const genericBody = `{
+	"build": {"project": "ci-repo", "branch": "refs/heads/main"},
+	"changes": [{"sha": "abc1234", "text": "first"}, {"sha": "bcd2345", "text": "second"}],
+	"user": {"login": "ci-bot", "id": 42}
+}`
+
+func genericRepos() repoMap {
+	repos := make(repoMap)
+	repos["ci-repo"] = config.Repo{
+		Secret:  "ci-token",
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
+		Generic: &config.Generic{
+			Repo:        "$.build.project",
+			Ref:         "$.build.branch",
+			Commit:      "$.changes[-1].sha",
+			Author:      "user.login",
+			Message:     "$.changes[-1].text",
+			TokenHeader: "X-CI-Token",
+		},
+	}
+	repos["other-repo"] = config.Repo{
+		Folders: []config.Folder{{Path: "/path/to/other"}},
+		Generic: &config.Generic{Repo: "$.name"},
+	}
+	return repos
+}
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(genericBody), &doc); err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected string
+		found    bool
+	}{
+		{"$.build.project", "ci-repo", true},
+		{"build.branch", "refs/heads/main", true},
+		{"$.changes[0].sha", "abc1234", true},
+		{"$.changes[-1].text", "second", true},
+		{"$.user.id", "42", true},
+		{"$.changes[5].sha", "", false},
+		{"$.build.missing", "", false},
+		{"$.build", "", false},
+	}
+
+	for _, tt := range tests {
+		value, found := jsonPath(doc, tt.expr)
+		if value != tt.expected || found != tt.found {
+			t.Errorf("jsonPath(%s) = %q, %v, expected %q, %v", tt.expr, value, found, tt.expected, tt.found)
+		}
+	}
+}
+
+func TestGenericPayload(t *testing.T) {
+	p := newGenericProvider(genericRepos())
+
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(genericBody))
+	if !p.Detect(req, []byte(genericBody)) {
+		t.Fatal("Expected generic payload to be detected")
+	}
+
+	result, err := p.Parse(req, []byte(genericBody))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := Payload{Event: EventPush, Repo: "ci-repo", FullName: "ci-repo", Ref: "refs/heads/main", CommitId: "bcd2345", Name: "ci-bot", Message: "second"}
+	if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, *result)
+	}
+
+	if p.Detect(req, []byte(`{"build": {"project": "unknown"}}`)) {
+		t.Error("Expected payload of unknown repository not to be detected")
+	}
+}
+
+func TestHandleGenericToken(t *testing.T) {
+	handler := New(genericRepos(), 1, 1, &mockPuller{}, nil, "")
+
+	for token, expected := range map[string]int{"ci-token": http.StatusAccepted, "wrong": http.StatusBadRequest} {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(genericBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-CI-Token", token)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Token %s: expected status %d, got %d", token, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"net/http"
+)
+
+// giteaEventHeaders are event headers of Gitea family, Forgejo sends Gitea ones too
+var giteaEventHeaders = []string{"X-Forgejo-Event", "X-Gitea-Event", "X-Gogs-Event"}
+
+// giteaSignatureHeaders carry hex encoded HMAC-SHA256 of the request body
+var giteaSignatureHeaders = []string{"X-Forgejo-Signature", "X-Gitea-Signature", "X-Gogs-Signature"}
+
+// zeroCommit is sent as after commit of deleted ref
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+type giteaCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"author"`
+}
+
+type giteaPayload struct {
+	Ref        string           `json:"ref"`
+	After      string           `json:"after"`
+	HeadCommit *giteaCommit     `json:"head_commit"`
+	Commits    []giteaCommit    `json:"commits"`
+	Repository githubRepository `json:"repository"`
+	Pusher     struct {
+		Login    string `json:"login"`
+		Username string `json:"username"`
+		FullName string `json:"full_name"`
+		Email    string `json:"email"`
+	} `json:"pusher"`
+}
+
+// giteaEvent returns event type sent by Gitea, Forgejo or Gogs, empty for other providers
+func giteaEvent(r *http.Request) string {
+	return firstHeader(r, giteaEventHeaders)
+}
+
+type giteaProvider struct{}
+
+func (giteaProvider) Name() string {
+	return "gitea"
+}
+
+func (giteaProvider) Detect(r *http.Request, _ []byte) bool {
+	return giteaEvent(r) != ""
+}
+
+func (giteaProvider) Parse(r *http.Request, body []byte) (*Payload, error) {
+	payload, err := formPayload(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch event := giteaEvent(r); event {
+	case "push":
+		return giteaPush(payload)
+	case "ping", "release", "pull_request":
+		// these payloads are GitHub compatible
+		return githubEvent(event, payload)
+	default:
+		return nil, fmt.Errorf("%w: gitea event %s", errIgnored, event)
+	}
+}
+
+func giteaPush(payload []byte) (*Payload, error) {
+	pl := &giteaPayload{}
+	if err := json.Unmarshal(payload, pl); err != nil {
+		return nil, err
+	}
+
+	if pl.After == zeroCommit {
+		return nil, fmt.Errorf("%w: ref %s of %s deleted", errIgnored, pl.Ref, pl.Repository.FullName)
+	}
+
+	name := pl.Pusher.FullName
+	if name == "" {
+		name = pl.Pusher.Login
+	}
+	if name == "" {
+		name = pl.Pusher.Username
+	}
+
+	p := Payload{
+		Event:    refEvent(pl.Ref),
+		Name:     name,
+		Email:    pl.Pusher.Email,
+		CommitId: pl.After,
+		Repo:     pl.Repository.Name,
+		FullName: pl.Repository.FullName,
+		URLs:     pl.Repository.urls(),
+		Ref:      pl.Ref,
+	}
+
+	commit := pl.HeadCommit
+	if commit == nil && len(pl.Commits) > 0 {
+		commit = &pl.Commits[len(pl.Commits)-1]
+	}
+
+	if commit != nil {
+		p.Message = commit.Message
+		if p.CommitId == "" {
+			p.CommitId = commit.ID
+		}
+	}
+
+	return &p, nil
+}
+
+func (giteaProvider) Verify(r *http.Request, body []byte, repo config.Repo) error {
+	return verifySignature(repo, body, firstHeader(r, giteaSignatureHeaders), "")
+}
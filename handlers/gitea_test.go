@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"gitwh/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const giteaPushBody = `{
+	"ref": "refs/heads/main",
+	"after": "bcd2345",
+	"commits": [{"id": "abc1234", "message": "first"}, {"id": "bcd2345", "message": "second"}],
+	"repository": {"name": "forge-repo", "full_name": "acme/forge-repo"},
+	"pusher": {"login": "forgeuser", "email": "forge@example.com"}
+}`
+
+func TestGiteaPayload(t *testing.T) {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(giteaPushBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Gitea-Event", "push")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "forge-repo" {
+		t.Errorf("Expected Repo forge-repo, got %s", result.Repo)
+	}
+
+	if result.Ref != "refs/heads/main" {
+		t.Errorf("Expected Ref refs/heads/main, got %s", result.Ref)
+	}
+
+	if result.CommitId != "bcd2345" || result.Message != "second" {
+		t.Errorf("Expected last commit bcd2345 'second', got %s '%s'", result.CommitId, result.Message)
+	}
+
+	if result.Name != "forgeuser" || result.Email != "forge@example.com" {
+		t.Errorf("Expected pusher forgeuser (forge@example.com), got %s (%s)", result.Name, result.Email)
+	}
+}
+
+func TestGiteaPayloadDeletedBranch(t *testing.T) {
+	body := `{"ref": "refs/heads/feature", "after": "0000000000000000000000000000000000000000", "repository": {"name": "forge-repo"}}`
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitea-Event", "push")
+
+	if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected branch deletion to be ignored, got %v", err)
+	}
+}
+
+func TestGogsPayloadForm(t *testing.T) {
+	form := url.Values{}
+	form.Add("payload", giteaPushBody)
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Gogs-Event", "push")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "forge-repo" {
+		t.Errorf("Expected Repo forge-repo, got %s", result.Repo)
+	}
+}
+
+func TestHandleGiteaSignature(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["forge-repo"] = config.Repo{
+		Secret:  "forge-secret",
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
+	}
+	handler := New(repos, 10, 1, &mockPuller{}, nil, "")
+
+	body := []byte(giteaPushBody)
+	tests := []struct {
+		header   string
+		secret   string
+		expected int
+	}{
+		{"X-Gitea-Signature", "forge-secret", http.StatusAccepted},
+		{"X-Forgejo-Signature", "forge-secret", http.StatusAccepted},
+		{"X-Gogs-Signature", "forge-secret", http.StatusAccepted},
+		{"X-Gitea-Signature", "wrong-secret", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gitea-Event", "push")
+		req.Header.Set(tt.header, strings.TrimPrefix(signBody(tt.secret, body), "sha256="))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.expected {
+			t.Errorf("%s signed with %s: expected status %d, got %d", tt.header, tt.secret, tt.expected, w.Code)
+		}
+	}
+}
+
+func TestHandleGiteaNonPushEvent(t *testing.T) {
+	handler := New(make(map[string]config.Repo), 1, 1, &mockPuller{}, nil, "")
+
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forgejo-Event", "issues")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+}
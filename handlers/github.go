@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"net/http"
+	"strings"
+)
+
+// githubRepository is a repository object of GitHub and Gitea family payloads
+type githubRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// urls returns URLs repository could be configured with
+func (r githubRepository) urls() []string {
+	return []string{r.CloneURL, r.SSHURL, r.HTMLURL}
+}
+
+type githubPayload struct {
+	Ref    string `json:"ref"`
+	Pusher struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"pusher"`
+	Commit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"head_commit"`
+	Repository githubRepository `json:"repository"`
+	URL        string           `json:"git_url"`
+	// Deleted is set on ref deletion, head_commit is null then
+	Deleted bool `json:"deleted"`
+}
+
+type githubReleasePayload struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		Author  struct {
+			Login string `json:"login"`
+		} `json:"author"`
+	} `json:"release"`
+	Repository githubRepository `json:"repository"`
+}
+
+type githubPullRequestPayload struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Title          string `json:"title"`
+		Merged         bool   `json:"merged"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+		Base           struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		MergedBy struct {
+			Login string `json:"login"`
+		} `json:"merged_by"`
+	} `json:"pull_request"`
+	Repository githubRepository `json:"repository"`
+}
+
+type githubProvider struct{}
+
+func (githubProvider) Name() string {
+	return "github"
+}
+
+func (githubProvider) Detect(r *http.Request, _ []byte) bool {
+	return r.Header.Get("X-GitHub-Event") != ""
+}
+
+func (githubProvider) Parse(r *http.Request, body []byte) (*Payload, error) {
+	payload, err := formPayload(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "", "push":
+		return githubPush(payload)
+	case "ping", "release", "pull_request":
+		return githubEvent(event, payload)
+	default:
+		return nil, fmt.Errorf("%w: github event %s", errIgnored, event)
+	}
+}
+
+func githubPush(payload []byte) (*Payload, error) {
+	pl := &githubPayload{}
+	if err := json.Unmarshal(payload, pl); err != nil {
+		return nil, err
+	}
+
+	if pl.Deleted {
+		return nil, fmt.Errorf("%w: ref %s of %s deleted", errIgnored, pl.Ref, pl.Repository.FullName)
+	}
+
+	p := Payload{
+		Event:    refEvent(pl.Ref),
+		Name:     pl.Pusher.Name,
+		Email:    pl.Pusher.Email,
+		CommitId: pl.Commit.ID,
+		Message:  pl.Commit.Message,
+		Repo:     pl.Repository.Name,
+		FullName: pl.Repository.FullName,
+		URLs:     pl.Repository.urls(),
+		Ref:      pl.Ref,
+	}
+
+	return &p, nil
+}
+
+// githubEvent parses ping, release and pull_request events shared by GitHub and Gitea family.
+// Only published releases and merged pull requests are reported, others are ignored
+func githubEvent(event string, payload []byte) (*Payload, error) {
+	switch event {
+	case "ping":
+		pl := &githubPayload{}
+		if err := json.Unmarshal(payload, pl); err != nil {
+			return nil, err
+		}
+		return &Payload{Event: EventPing, Repo: pl.Repository.Name, FullName: pl.Repository.FullName}, nil
+
+	case "release":
+		pl := &githubReleasePayload{}
+		if err := json.Unmarshal(payload, pl); err != nil {
+			return nil, err
+		}
+		if pl.Action != "published" {
+			return nil, fmt.Errorf("%w: release %s %s", errIgnored, pl.Release.TagName, pl.Action)
+		}
+		return &Payload{
+			Event:    EventRelease,
+			Name:     pl.Release.Author.Login,
+			Message:  pl.Release.Name,
+			Repo:     pl.Repository.Name,
+			FullName: pl.Repository.FullName,
+			URLs:     pl.Repository.urls(),
+			Ref:      "refs/tags/" + pl.Release.TagName,
+		}, nil
+
+	case "pull_request":
+		pl := &githubPullRequestPayload{}
+		if err := json.Unmarshal(payload, pl); err != nil {
+			return nil, err
+		}
+		if pl.Action != "closed" || !pl.PullRequest.Merged {
+			return nil, fmt.Errorf("%w: pull request %s %s", errIgnored, pl.PullRequest.Title, pl.Action)
+		}
+		return &Payload{
+			Event:    EventMerge,
+			Name:     pl.PullRequest.MergedBy.Login,
+			CommitId: pl.PullRequest.MergeCommitSHA,
+			Message:  pl.PullRequest.Title,
+			Repo:     pl.Repository.Name,
+			FullName: pl.Repository.FullName,
+			URLs:     pl.Repository.urls(),
+			Ref:      "refs/heads/" + pl.PullRequest.Base.Ref,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: event %s", errIgnored, event)
+}
+
+func (githubProvider) Verify(r *http.Request, body []byte, repo config.Repo) error {
+	return verifySignature(repo, body,
+		strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="),
+		strings.TrimPrefix(r.Header.Get("X-Hub-Signature"), "sha1="),
+	)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"log"
+	"net/http"
+)
+
+type gitlabProject struct {
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	HTTPURL           string `json:"git_http_url"`
+	SSHURL            string `json:"git_ssh_url"`
+	WebURL            string `json:"web_url"`
+}
+
+type gitlabPayload struct {
+	Ref         string        `json:"ref"`
+	After       string        `json:"after"`
+	CheckoutSHA string        `json:"checkout_sha"`
+	UserName    string        `json:"user_name"`
+	UserEmail   string        `json:"user_email"`
+	Repository  gitlabProject `json:"project"`
+	Commits     []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		}
+	} `json:"commits"`
+}
+
+type gitlabMergeRequestPayload struct {
+	User struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"user"`
+	Repository gitlabProject `json:"project"`
+	Attributes struct {
+		Action         string `json:"action"`
+		Title          string `json:"title"`
+		TargetBranch   string `json:"target_branch"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+	} `json:"object_attributes"`
+}
+
+type gitlabReleasePayload struct {
+	Action     string        `json:"action"`
+	Tag        string        `json:"tag"`
+	Name       string        `json:"name"`
+	Repository gitlabProject `json:"project"`
+	Commit     struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+// urls returns URLs project could be configured with
+func (p gitlabProject) urls() []string {
+	return []string{p.HTTPURL, p.SSHURL, p.WebURL}
+}
+
+type gitlabProvider struct{}
+
+func (gitlabProvider) Name() string {
+	return "gitlab"
+}
+
+func (gitlabProvider) Detect(r *http.Request, _ []byte) bool {
+	return r.Header.Get("X-Gitlab-Event") != ""
+}
+
+// Parse handles push, tag push, merge request and release hooks. Requests without
+// X-Gitlab-Event are treated as push ones
+func (gitlabProvider) Parse(r *http.Request, body []byte) (*Payload, error) {
+	switch event := r.Header.Get("X-Gitlab-Event"); event {
+	case "", "Push Hook", "Tag Push Hook":
+		return gitlabPush(body)
+	case "Merge Request Hook":
+		return gitlabMergeRequest(body)
+	case "Release Hook":
+		return gitlabRelease(body)
+	default:
+		return nil, fmt.Errorf("%w: gitlab event %s", errIgnored, event)
+	}
+}
+
+func gitlabPush(body []byte) (*Payload, error) {
+	pl := &gitlabPayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	if pl.After == zeroCommit {
+		return nil, fmt.Errorf("%w: ref %s of %s deleted", errIgnored, pl.Ref, pl.Repository.PathWithNamespace)
+	}
+
+	if len(pl.Commits) == 0 {
+		// tag pushes carry no commits
+		if pl.CheckoutSHA == "" {
+			return nil, fmt.Errorf("invalid commit %v", pl)
+		}
+
+		return &Payload{
+			Event:    refEvent(pl.Ref),
+			Name:     pl.UserName,
+			Email:    pl.UserEmail,
+			CommitId: pl.CheckoutSHA,
+			Repo:     pl.Repository.Name,
+			FullName: pl.Repository.PathWithNamespace,
+			URLs:     pl.Repository.urls(),
+			Ref:      pl.Ref,
+		}, nil
+	}
+
+	if len(pl.Commits) > 1 {
+		log.Printf("Multi Commit in one hook (len: %d)", len(pl.Commits))
+	}
+
+	// commits are listed oldest first, checkout_sha is the pushed head
+	commit := pl.Commits[len(pl.Commits)-1]
+	commitId := pl.CheckoutSHA
+	if commitId == "" {
+		commitId = commit.ID
+	}
+
+	p := Payload{
+		Event:    refEvent(pl.Ref),
+		Name:     commit.Author.Name,
+		Email:    commit.Author.Email,
+		CommitId: commitId,
+		Message:  commit.Message,
+		Repo:     pl.Repository.Name,
+		FullName: pl.Repository.PathWithNamespace,
+		URLs:     pl.Repository.urls(),
+		Ref:      pl.Ref,
+	}
+
+	return &p, nil
+}
+
+// gitlabMergeRequest reports merged merge requests only
+func gitlabMergeRequest(body []byte) (*Payload, error) {
+	pl := &gitlabMergeRequestPayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	if pl.Attributes.Action != "merge" {
+		return nil, fmt.Errorf("%w: merge request %s %s", errIgnored, pl.Attributes.Title, pl.Attributes.Action)
+	}
+
+	p := Payload{
+		Event:    EventMerge,
+		Name:     pl.User.Name,
+		Email:    pl.User.Email,
+		CommitId: pl.Attributes.MergeCommitSHA,
+		Message:  pl.Attributes.Title,
+		Repo:     pl.Repository.Name,
+		FullName: pl.Repository.PathWithNamespace,
+		URLs:     pl.Repository.urls(),
+		Ref:      "refs/heads/" + pl.Attributes.TargetBranch,
+	}
+
+	return &p, nil
+}
+
+// gitlabRelease reports created releases only
+func gitlabRelease(body []byte) (*Payload, error) {
+	pl := &gitlabReleasePayload{}
+	if err := json.Unmarshal(body, pl); err != nil {
+		return nil, err
+	}
+
+	if pl.Action != "create" {
+		return nil, fmt.Errorf("%w: release %s %s", errIgnored, pl.Tag, pl.Action)
+	}
+
+	p := Payload{
+		Event:    EventRelease,
+		CommitId: pl.Commit.ID,
+		Message:  pl.Name,
+		Repo:     pl.Repository.Name,
+		FullName: pl.Repository.PathWithNamespace,
+		URLs:     pl.Repository.urls(),
+		Ref:      "refs/tags/" + pl.Tag,
+	}
+
+	return &p, nil
+}
+
+func (gitlabProvider) Verify(r *http.Request, _ []byte, repo config.Repo) error {
+	return verifyToken(repo, r.Header.Get("X-Gitlab-Token"))
+}
@@ -1,81 +1,128 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"gitwh/config"
+	"gitwh/queue"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gitwh/puller"
 )
 
 type repoMap map[string]config.Repo
 
+// Event types of Payload
+const (
+	EventPush    = "push"
+	EventTag     = "tag"
+	EventRelease = "release"
+	EventMerge   = "merge"
+	EventPing    = "ping"
+)
+
+// defaultEvents trigger pull when repository has no events configured
+var defaultEvents = []string{EventPush}
+
+// retryAfter is a delay in seconds suggested to hook senders when queue is full
+const retryAfter = 10
+
+// jobResponse is sent back on accepted hook
+type jobResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// errIgnored reports a valid hook which should not trigger pull
+var errIgnored = errors.New("ignored")
+
+// errPing reports a ping hook sent on hook creation
+var errPing = errors.New("ping")
+
 type handler struct {
-	event  chan []string
-	repos  repoMap
-	puller puller.Puller
+	event   chan puller.Job
+	repos   repoMap
+	urls    map[string]string
+	puller  puller.Puller
+	generic *genericProvider
+	journal *queue.Journal
+	// left counts folders of jobs coalesced into pulls of other jobs, which are not finished yet
+	left map[string]int
+	lock *sync.Mutex
+	// adminToken enables admin API, it must be sent as bearer token
+	adminToken string
 }
 
+// Payload is an event parsed from webhook request
 type Payload struct {
+	// Event is one of Event* constants, empty one is treated as EventPush
+	Event    string
 	Name     string
 	Email    string
 	CommitId string
 	Message  string
 	Repo     string
-	Secret   string
-}
-
-type githubPayload struct {
-	Pusher struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
-	} `json:"pusher"`
-	Commit struct {
-		ID      string `json:"id"`
-		Message string `json:"message"`
-	} `json:"head_commit"`
-	Repository struct {
-		Name string `json:"name"`
-	} `json:"repository"`
-	URL string `json:"git_url"`
-}
-
-type gitlabPayload struct {
-	Repository struct {
-		Name string `json:"name"`
-	} `json:"project"`
-	Commits []struct {
-		ID      string `json:"id"`
-		Message string `json:"message"`
-		Author  struct {
-			Name  string `json:"name"`
-			Email string `json:"email"`
-		}
-	} `json:"commits"`
+	// FullName is repository path with owner or namespace, e.g. "owner/name"
+	FullName string
+	// URLs are clone and web URLs of repository
+	URLs []string
+	Ref  string
 }
 
-// New creates new handlers for Webhook Server
-func New(repositories repoMap, bufferSize int, puller puller.Puller) http.Handler {
+// New creates new handlers for Webhook Server.
+// Up to workers jobs are pulled at once, up to bufferSize more wait in queue.
+// Accepted jobs are recorded into journal unless it is nil, pending jobs of journal are replayed.
+// Admin API is served only if adminToken is set
+func New(repositories repoMap, bufferSize, workers int, p puller.Puller, journal *queue.Journal, adminToken string) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.RealIP)
 
 	h := &handler{
-		event:  make(chan []string, bufferSize),
-		repos:  repositories,
-		puller: puller,
+		event:      make(chan puller.Job, bufferSize),
+		repos:      repositories,
+		urls:       urlIndex(repositories),
+		puller:     p,
+		generic:    newGenericProvider(repositories),
+		journal:    journal,
+		left:       make(map[string]int),
+		lock:       &sync.Mutex{},
+		adminToken: adminToken,
 	}
 
 	r.HandleFunc("/", h.notFound)
 	r.HandleFunc("/wh", h.handle)
+	r.HandleFunc("/wh/{repo}", h.handle)
+	r.HandleFunc("/wh/{provider}/{repo}", h.handle)
+
+	if adminToken != "" {
+		r.Post("/admin/rollback/{repo}", h.rollback)
+	}
 
-	go h.pull()
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go h.pull()
+	}
+	if journal != nil {
+		go h.replay(journal.Pending())
+	}
 	return r
 }
 
@@ -84,109 +131,381 @@ func (h *handler) notFound(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Not Found", http.StatusNotFound)
 }
 
-func (h *handler) githubPayload(r *http.Request) (*Payload, error) {
-	if err := r.ParseForm(); err != nil {
+func (h *handler) getJob(r *http.Request) (*puller.Job, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		return nil, err
 	}
 
-	payload := []byte(r.FormValue("payload"))
-	pl := &githubPayload{}
-	if err := json.Unmarshal(payload, pl); err != nil {
+	provider, err := h.getProvider(r, body)
+	if err != nil {
 		return nil, err
 	}
 
-	p := Payload{
-		Name:     pl.Pusher.Name,
-		Email:    pl.Pusher.Email,
-		CommitId: pl.Commit.ID,
-		Message:  pl.Commit.Message,
-		Repo:     pl.Repository.Name,
+	pl, err := provider.Parse(r, body)
+	if err != nil {
+		return nil, err
 	}
 
-	return &p, nil
-}
+	if pl.Event == "" {
+		pl.Event = EventPush
+	}
 
-func (h *handler) gitlabPayload(r *http.Request) (*Payload, error) {
-	body, err := io.ReadAll(r.Body)
+	if pl.Event == EventPing {
+		return nil, fmt.Errorf("%w: %s hook of %s", errPing, provider.Name(), pl.FullName)
+	}
+
+	name, repo, err := h.getRepo(r, pl)
 	if err != nil {
 		return nil, err
 	}
 
-	pl := &gitlabPayload{}
-	if err := json.Unmarshal(body, pl); err != nil {
+	// commit reaches git commands, so only hex object IDs are accepted from any provider
+	if pl.CommitId != "" && !puller.IsCommitID(pl.CommitId) {
+		return nil, fmt.Errorf("invalid commit %q of %s", pl.CommitId, name)
+	}
+
+	if err := checkBasicAuth(repo, r); err != nil {
 		return nil, err
 	}
 
-	if len(pl.Commits) == 0 {
-		return nil, fmt.Errorf("invalid commit %v", pl)
+	if err := provider.Verify(r, body, repo); err != nil {
+		return nil, err
 	}
 
-	if len(pl.Commits) > 1 {
-		log.Printf("Multi Commit in one hook (len: %d)", len(pl.Commits))
+	if !matchEvent(repo.Events, pl.Event) {
+		return nil, fmt.Errorf("%w: %s event of %s", errIgnored, pl.Event, name)
 	}
 
-	commit := pl.Commits[0]
+	if strings.HasPrefix(pl.Ref, "refs/heads/") && !matchRef(repo.Branches, pl.Ref) {
+		return nil, fmt.Errorf("%w: ref %s of %s does not match branches", errIgnored, pl.Ref, name)
+	}
+
+	folders := branchFolders(repo.Folders, pl.Ref)
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("%w: no folders track ref %s of %s", errIgnored, pl.Ref, name)
+	}
+	resolved := make([]config.Folder, 0, len(folders))
+	for _, folder := range folders {
+		resolved = append(resolved, repo.ResolveFolder(folder))
+	}
 
-	p := Payload{
-		Name:     commit.Author.Name,
-		Email:    commit.Author.Email,
-		CommitId: commit.ID,
-		Message:  commit.Message,
-		Repo:     pl.Repository.Name,
-		Secret:   r.Header.Get("X-Gitlab-Token"),
+	fmt.Printf("%s %s %s made by %v (%v)\n", r.RemoteAddr, name, pl.Event, pl.Name, pl.Email)
+	if pl.Message != "" {
+		fmt.Printf("%s Commit message : %s\n", pl.CommitId, pl.Message)
 	}
+	return &puller.Job{
+		Repo:     name,
+		Folders:  resolved,
+		CommitId: pl.CommitId,
+		Ref:      pl.Ref,
+		Pusher:   pl.Name,
+		Debounce: time.Duration(repo.Debounce) * time.Second,
+		Workers:  repo.Workers,
+	}, nil
+}
 
-	return &p, nil
+// normalizeURL makes clone URL comparable: lower case, without trailing slash and .git suffix
+func normalizeURL(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
 }
 
-func (h *handler) getPayload(r *http.Request) (*Payload, error) {
-	contentType := r.Header.Get("Content-Type")
-	log.Printf("Content-Type: %s", contentType)
+// urlIndex maps normalized repository URLs to repository names
+func urlIndex(repos repoMap) map[string]string {
+	index := make(map[string]string)
+	for name, repo := range repos {
+		for _, u := range repo.URLs {
+			index[normalizeURL(u)] = name
+		}
+	}
+	return index
+}
 
-	if contentType == "application/json" {
-		return h.gitlabPayload(r)
+// getProvider returns provider selected by URL or detected by request
+func (h *handler) getProvider(r *http.Request, body []byte) (Provider, error) {
+	name := chi.URLParam(r, "provider")
+	if name == "" {
+		return detectProvider(r, body, h.generic), nil
 	}
 
-	return h.githubPayload(r)
+	for _, p := range append(Providers(), h.generic) {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("provider %s not supported", name)
 }
 
-func (h *handler) getRepositoryPath(r *http.Request) ([]string, error) {
-	pl, err := h.getPayload(r)
+// getRepo returns repository selected by URL or found by payload.
+// Repository selected by URL must be the one payload refers to
+func (h *handler) getRepo(r *http.Request, pl *Payload) (string, config.Repo, error) {
+	name, err := url.PathUnescape(chi.URLParam(r, "repo"))
 	if err != nil {
-		return nil, err
+		return "", config.Repo{}, err
+	}
+
+	if name == "" {
+		name, repo, ok := h.findRepo(pl)
+		if !ok {
+			return "", config.Repo{}, fmt.Errorf("repository %s (%s) not supported", pl.Repo, pl.FullName)
+		}
+		return name, repo, nil
 	}
 
-	repo, ok := h.repos[pl.Repo]
+	repo, ok := h.repos[name]
 	if !ok {
-		return nil, fmt.Errorf("repository %s not supported", pl.Repo)
+		return "", config.Repo{}, fmt.Errorf("repository %s not supported", name)
 	}
 
-	if repo.Secret != "" && pl.Secret != repo.Secret {
-		return nil, fmt.Errorf("secret is not valid. Given value: %s", pl.Secret)
+	if !repoMatches(name, repo, pl) {
+		return "", config.Repo{}, fmt.Errorf("payload repository %s (%s) does not match %s", pl.Repo, pl.FullName, name)
 	}
+	return name, repo, nil
+}
 
-	fmt.Printf("%s %s Push made by %v (%v)\n", r.RemoteAddr, pl.Repo, pl.Name, pl.Email)
-	if pl.Message != "" {
-		fmt.Printf("%s Commit message : %s\n", pl.CommitId, pl.Message)
+// repoMatches reports whether payload refers to the repository by full name, URL or short name.
+// Repositories with configured URLs are never matched by short name
+func repoMatches(name string, repo config.Repo, pl *Payload) bool {
+	if pl.FullName != "" && pl.FullName == name {
+		return true
+	}
+
+	for _, u := range pl.URLs {
+		for _, repoURL := range repo.URLs {
+			if u != "" && normalizeURL(u) == normalizeURL(repoURL) {
+				return true
+			}
+		}
+	}
+
+	return len(repo.URLs) == 0 && pl.Repo == name
+}
+
+// findRepo looks repository up by full name, then by clone URL and by short name as a fallback.
+// Repositories with configured URLs are never matched by short name
+func (h *handler) findRepo(pl *Payload) (string, config.Repo, bool) {
+	if repo, ok := h.repos[pl.FullName]; ok && pl.FullName != "" {
+		return pl.FullName, repo, true
+	}
+
+	for _, u := range pl.URLs {
+		if name, ok := h.urls[normalizeURL(u)]; ok && u != "" {
+			return name, h.repos[name], true
+		}
+	}
+
+	if repo, ok := h.repos[pl.Repo]; ok && len(repo.URLs) == 0 {
+		return pl.Repo, repo, true
+	}
+	return "", config.Repo{}, false
+}
+
+// refEvent returns event type of pushed ref
+func refEvent(ref string) string {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return EventTag
+	}
+	return EventPush
+}
+
+// matchEvent reports whether event is enabled for repository, push only by default
+func matchEvent(events []string, event string) bool {
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// branchFolders returns folders tracking the branch of ref, folders without branch track any ref.
+// Tags are not branches, so all folders are returned for them
+func branchFolders(folders []config.Folder, ref string) []config.Folder {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return folders
 	}
-	return repo.Folders, nil
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	var res []config.Folder
+	for _, folder := range folders {
+		if folder.Branch == "" || folder.Branch == branch {
+			res = append(res, folder)
+		}
+	}
+	return res
+}
+
+// matchRef reports whether ref matches any of branch glob patterns.
+// Patterns are matched against short branch name and full ref, empty list matches any ref
+func matchRef(patterns []string, ref string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBasicAuth validates request credentials when repository requires basic auth
+func checkBasicAuth(repo config.Repo, r *http.Request) error {
+	if repo.BasicAuth == nil {
+		return nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return fmt.Errorf("basic auth credentials are missing")
+	}
+
+	validUser := subtle.ConstantTimeCompare([]byte(username), []byte(repo.BasicAuth.Username))
+	validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(repo.BasicAuth.Password))
+	if validUser&validPassword != 1 {
+		return fmt.Errorf("basic auth credentials are not valid. Given user: %s", username)
+	}
+	return nil
 }
 
 func (h *handler) handle(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("Request from %s\n", r.RemoteAddr)
-	repoPath, err := h.getRepositoryPath(r)
+	job, err := h.getJob(r)
+	if errors.Is(err, errPing) {
+		fmt.Printf("[%s] Ping : %v\n", r.RemoteAddr, err)
+		_, _ = w.Write([]byte("pong\n"))
+		return
+	}
+	if errors.Is(err, errIgnored) {
+		fmt.Printf("[%s] Ignored : %v\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("ignored\n"))
+		return
+	}
 	if err != nil {
 		fmt.Printf("[%s] Bad request : %v\n", r.RemoteAddr, err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	h.event <- repoPath
+
+	h.enqueue(w, r, job)
+}
+
+// enqueue queues job and answers 202 with job ID, or 503 if queue is full
+func (h *handler) enqueue(w http.ResponseWriter, r *http.Request, job *puller.Job) {
+	job.ID = newJobID()
+	if h.journal != nil {
+		if err := h.journal.Accept(*job); err != nil {
+			fmt.Printf("[%s] Job %s is not journaled : %v\n", r.RemoteAddr, job.ID, err)
+		}
+	}
+
+	select {
+	case h.event <- *job:
+	default:
+		fmt.Printf("[%s] Queue is full, job %s rejected\n", r.RemoteAddr, job.ID)
+		h.done(job.ID)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Printf("[%s] Job %s queued\n", r.RemoteAddr, job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(jobResponse{ID: job.ID, Status: "queued"})
+}
+
+// newJobID returns random hex job identifier
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// replay queues jobs left unfinished by previous run
+func (h *handler) replay(jobs []puller.Job) {
+	for _, job := range jobs {
+		fmt.Printf("Replaying job %s\n", job.ID)
+		h.event <- job
+	}
 }
 
+// done records job completion into journal
+func (h *handler) done(id string) {
+	if h.journal == nil {
+		return
+	}
+	if err := h.journal.Done(id); err != nil {
+		fmt.Printf("Job %s completion is not journaled : %v\n", id, err)
+	}
+}
+
+// pull runs queued jobs one by one, New starts one pull per worker.
+// Debounced jobs wait for their window outside of workers, so they don't hold up other repositories
 func (h *handler) pull() {
-	for path := range h.event {
-		if err := h.puller.Pull(path); err != nil {
-			fmt.Printf("Pull error: %v", err)
+	for job := range h.event {
+		if job.Debounce > 0 {
+			go h.run(job)
+			continue
 		}
+		h.run(job)
+	}
+}
+
+// run pulls job, it is completed once its folders coalesced into pulls of other jobs are pulled as well
+func (h *handler) run(job puller.Job) {
+	res, err := h.puller.Pull(context.Background(), job)
+	if err != nil {
+		fmt.Printf("Job %s pull error: %v\n", job.ID, err)
 	}
+
+	coalesced := 0
+	if res != nil {
+		for _, f := range res.Folders {
+			if f.Coalesced {
+				coalesced++
+				continue
+			}
+			if f.Err == nil && f.OldHead != f.NewHead {
+				fmt.Printf("Job %s [%s] %s -> %s\n", job.ID, f.Path, f.OldHead, f.NewHead)
+			}
+			// the first job is the one which started the pull
+			if len(f.Jobs) > 1 {
+				for _, id := range f.Jobs[1:] {
+					h.finish(id, -1)
+				}
+			}
+		}
+	}
+	h.finish(job.ID, coalesced)
+}
+
+// finish adds n to folders of job left to other pulls, job is done once none is left.
+// Job adds its coalesced folders and pulls serving them subtract one each, in any order
+func (h *handler) finish(id string, n int) {
+	h.lock.Lock()
+	left := h.left[id] + n
+	if left != 0 {
+		h.left[id] = left
+		h.lock.Unlock()
+		return
+	}
+	delete(h.left, id)
+	h.lock.Unlock()
+
+	h.done(id)
 }
@@ -2,26 +2,59 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"gitwh/config"
+	"gitwh/puller"
+	"gitwh/queue"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type mockPuller struct {
-	pulledPaths [][]string
+	mu          sync.Mutex
+	pulledJobs  []puller.Job
 	shouldError bool
 }
 
-func (m *mockPuller) Pull(paths []string) error {
-	m.pulledPaths = append(m.pulledPaths, paths)
+func (m *mockPuller) Pull(ctx context.Context, job puller.Job) (*puller.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pulledJobs = append(m.pulledJobs, job)
 	if m.shouldError {
-		return &mockError{"pull error"}
+		return nil, &mockError{"pull error"}
 	}
-	return nil
+	return &puller.Result{JobID: job.ID}, nil
+}
+
+// parseRequest parses request by detected provider
+func parseRequest(r *http.Request) (*Payload, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return detectProvider(r, body).Parse(r, body)
+}
+
+// parseWith parses request by given provider
+func parseWith(p Provider, r *http.Request) (*Payload, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return p.Parse(r, body)
 }
 
 type mockError struct {
@@ -36,11 +69,11 @@ func TestNew(t *testing.T) {
 	repos := make(map[string]config.Repo)
 	repos["test-repo"] = config.Repo{
 		Secret:  "secret",
-		Folders: []string{"/path/to/repo"},
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
 	}
 	
 	puller := &mockPuller{}
-	handler := New(repos, 5, puller)
+	handler := New(repos, 5, 1, puller, nil, "")
 	
 	if handler == nil {
 		t.Error("Expected handler to be created")
@@ -50,7 +83,7 @@ func TestNew(t *testing.T) {
 func TestNotFound(t *testing.T) {
 	repos := make(map[string]config.Repo)
 	puller := &mockPuller{}
-	handler := New(repos, 1, puller)
+	handler := New(repos, 1, 1, puller, nil, "")
 	
 	req := httptest.NewRequest("GET", "/invalid", nil)
 	w := httptest.NewRecorder()
@@ -63,13 +96,6 @@ func TestNotFound(t *testing.T) {
 }
 
 func TestGithubPayload(t *testing.T) {
-	repos := make(map[string]config.Repo)
-	puller := &mockPuller{}
-	h := &handler{
-		repos:  repos,
-		puller: puller,
-	}
-	
 	payload := githubPayload{
 		Pusher: struct {
 			Name  string `json:"name"`
@@ -82,12 +108,10 @@ func TestGithubPayload(t *testing.T) {
 			ID      string `json:"id"`
 			Message string `json:"message"`
 		}{
-			ID:      "abc123",
+			ID:      "abc1234",
 			Message: "test commit",
 		},
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: githubRepository{
 			Name: "test-repo",
 		},
 	}
@@ -99,9 +123,9 @@ func TestGithubPayload(t *testing.T) {
 	req := httptest.NewRequest("POST", "/wh", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	
-	result, err := h.githubPayload(req)
+	result, err := parseWith(githubProvider{}, req)
 	if err != nil {
-		t.Fatalf("githubPayload failed: %v", err)
+		t.Fatalf("Parse failed: %v", err)
 	}
 	
 	if result.Name != "testuser" {
@@ -112,8 +136,8 @@ func TestGithubPayload(t *testing.T) {
 		t.Errorf("Expected Email test@example.com, got %s", result.Email)
 	}
 	
-	if result.CommitId != "abc123" {
-		t.Errorf("Expected CommitId abc123, got %s", result.CommitId)
+	if result.CommitId != "abc1234" {
+		t.Errorf("Expected CommitId abc1234, got %s", result.CommitId)
 	}
 	
 	if result.Message != "test commit" {
@@ -126,17 +150,8 @@ func TestGithubPayload(t *testing.T) {
 }
 
 func TestGitlabPayload(t *testing.T) {
-	repos := make(map[string]config.Repo)
-	puller := &mockPuller{}
-	h := &handler{
-		repos:  repos,
-		puller: puller,
-	}
-	
 	payload := gitlabPayload{
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: gitlabProject{
 			Name: "gitlab-repo",
 		},
 		Commits: []struct {
@@ -148,7 +163,7 @@ func TestGitlabPayload(t *testing.T) {
 			}
 		}{
 			{
-				ID:      "def456",
+				ID:      "def4567",
 				Message: "gitlab commit",
 				Author: struct {
 					Name  string `json:"name"`
@@ -166,9 +181,9 @@ func TestGitlabPayload(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Gitlab-Token", "gitlab-secret")
 	
-	result, err := h.gitlabPayload(req)
+	result, err := parseWith(gitlabProvider{}, req)
 	if err != nil {
-		t.Fatalf("gitlabPayload failed: %v", err)
+		t.Fatalf("Parse failed: %v", err)
 	}
 	
 	if result.Name != "gitlabuser" {
@@ -179,8 +194,8 @@ func TestGitlabPayload(t *testing.T) {
 		t.Errorf("Expected Email gitlab@example.com, got %s", result.Email)
 	}
 	
-	if result.CommitId != "def456" {
-		t.Errorf("Expected CommitId def456, got %s", result.CommitId)
+	if result.CommitId != "def4567" {
+		t.Errorf("Expected CommitId def4567, got %s", result.CommitId)
 	}
 	
 	if result.Message != "gitlab commit" {
@@ -191,23 +206,37 @@ func TestGitlabPayload(t *testing.T) {
 		t.Errorf("Expected Repo gitlab-repo, got %s", result.Repo)
 	}
 	
-	if result.Secret != "gitlab-secret" {
-		t.Errorf("Expected Secret gitlab-secret, got %s", result.Secret)
+	if err := (gitlabProvider{}).Verify(req, payloadBytes, config.Repo{Secret: "gitlab-secret"}); err != nil {
+		t.Errorf("Expected X-Gitlab-Token to be verified, got %v", err)
 	}
 }
 
-func TestGitlabPayloadNoCommits(t *testing.T) {
-	repos := make(map[string]config.Repo)
-	puller := &mockPuller{}
-	h := &handler{
-		repos:  repos,
-		puller: puller,
+func TestGitlabPayloadMultipleCommits(t *testing.T) {
+	body := `{
+		"ref": "refs/heads/main",
+		"checkout_sha": "bbb2222",
+		"project": {"name": "gitlab-repo"},
+		"commits": [
+			{"id": "aaa1111", "message": "first", "author": {"name": "first", "email": "first@example.com"}},
+			{"id": "bbb2222", "message": "second", "author": {"name": "second", "email": "second@example.com"}}
+		]
+	}`
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+
+	result, err := parseWith(gitlabProvider{}, req)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
 	}
-	
+
+	if result.CommitId != "bbb2222" || result.Message != "second" || result.Name != "second" {
+		t.Errorf("Expected latest pushed commit bbb2222, got %+v", result)
+	}
+}
+
+func TestGitlabPayloadNoCommits(t *testing.T) {
 	payload := gitlabPayload{
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: gitlabProject{
 			Name: "gitlab-repo",
 		},
 		Commits: []struct {
@@ -224,24 +253,28 @@ func TestGitlabPayloadNoCommits(t *testing.T) {
 	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(payloadBytes))
 	req.Header.Set("Content-Type", "application/json")
 	
-	_, err := h.gitlabPayload(req)
+	_, err := parseWith(gitlabProvider{}, req)
 	if err == nil {
 		t.Error("Expected error for payload with no commits")
 	}
 }
 
-func TestGetPayload(t *testing.T) {
-	repos := make(map[string]config.Repo)
-	puller := &mockPuller{}
-	h := &handler{
-		repos:  repos,
-		puller: puller,
+func TestGitlabPayloadDeletedBranch(t *testing.T) {
+	body := `{"ref": "refs/heads/feature", "after": "0000000000000000000000000000000000000000", "checkout_sha": null, "commits": [], "project": {"name": "gitlab-repo"}}`
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+
+	if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected branch deletion to be ignored, got %v", err)
 	}
-	
+}
+
+func TestGetPayload(t *testing.T) {
 	req := httptest.NewRequest("POST", "/wh", bytes.NewReader([]byte{}))
 	req.Header.Set("Content-Type", "application/json")
 	
-	_, err := h.getPayload(req)
+	_, err := parseRequest(req)
 	if err == nil {
 		t.Error("Expected error for invalid JSON payload")
 	}
@@ -251,11 +284,11 @@ func TestHandleValidRequest(t *testing.T) {
 	repos := make(map[string]config.Repo)
 	repos["test-repo"] = config.Repo{
 		Secret:  "",
-		Folders: []string{"/path/to/repo"},
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
 	}
 	
 	puller := &mockPuller{}
-	handler := New(repos, 1, puller)
+	handler := New(repos, 1, 1, puller, nil, "")
 	
 	payload := githubPayload{
 		Pusher: struct {
@@ -269,12 +302,10 @@ func TestHandleValidRequest(t *testing.T) {
 			ID      string `json:"id"`
 			Message string `json:"message"`
 		}{
-			ID:      "abc123",
+			ID:      "abc1234",
 			Message: "test commit",
 		},
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: githubRepository{
 			Name: "test-repo",
 		},
 	}
@@ -289,20 +320,39 @@ func TestHandleValidRequest(t *testing.T) {
 	
 	handler.ServeHTTP(w, req)
 	
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+}
+
+func TestHandleInvalidCommit(t *testing.T) {
+	repos := map[string]config.Repo{"test-repo": {Folders: []config.Folder{{Path: "/path/to/repo"}}}}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	for _, commit := range []string{"--exec=touch /tmp/pwned", "HEAD~1", "main"} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"repository":  map[string]string{"name": "test-repo"},
+			"head_commit": map[string]string{"id": commit},
+		})
+		req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "push")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %d", commit, http.StatusBadRequest, w.Code)
+		}
 	}
 }
 
 func TestHandleUnsupportedRepo(t *testing.T) {
 	repos := make(map[string]config.Repo)
 	puller := &mockPuller{}
-	handler := New(repos, 1, puller)
+	handler := New(repos, 1, 1, puller, nil, "")
 	
 	payload := githubPayload{
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: githubRepository{
 			Name: "unsupported-repo",
 		},
 	}
@@ -326,16 +376,14 @@ func TestHandleInvalidSecret(t *testing.T) {
 	repos := make(map[string]config.Repo)
 	repos["test-repo"] = config.Repo{
 		Secret:  "correct-secret",
-		Folders: []string{"/path/to/repo"},
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
 	}
 	
 	puller := &mockPuller{}
-	handler := New(repos, 1, puller)
+	handler := New(repos, 1, 1, puller, nil, "")
 	
 	payload := gitlabPayload{
-		Repository: struct {
-			Name string `json:"name"`
-		}{
+		Repository: gitlabProject{
 			Name: "test-repo",
 		},
 		Commits: []struct {
@@ -347,7 +395,7 @@ func TestHandleInvalidSecret(t *testing.T) {
 			}
 		}{
 			{
-				ID:      "def456",
+				ID:      "def4567",
 				Message: "gitlab commit",
 				Author: struct {
 					Name  string `json:"name"`
@@ -371,4 +419,568 @@ func TestHandleInvalidSecret(t *testing.T) {
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
 	}
-}
\ No newline at end of file
+}
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func githubFormRequest(repo string) (*http.Request, []byte) {
+	form := url.Values{}
+	form.Add("payload", `{"repository":{"name":"`+repo+`"},"head_commit":{"id":"abc1234"}}`)
+	body := []byte(form.Encode())
+
+	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, body
+}
+
+func TestHandleGithubSignature(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["test-repo"] = config.Repo{
+		Secret:  "gh-secret",
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
+	}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	req, body := githubFormRequest("test-repo")
+	req.Header.Set("X-Hub-Signature-256", signBody("gh-secret", body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	req, _ = githubFormRequest("test-repo")
+	req.Header.Set("X-Hub-Signature-256", signBody("wrong-secret", body))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for wrong signature, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	req, _ = githubFormRequest("test-repo")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for missing signature, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGithubPayloadJSON(t *testing.T) {
+	body := []byte(`{"repository":{"name":"json-repo"},"head_commit":{"id":"abc1234","message":"json commit"}}`)
+	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-GitHub-Event", "push")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "json-repo" {
+		t.Errorf("Expected Repo json-repo, got %s", result.Repo)
+	}
+
+	if result.CommitId != "abc1234" {
+		t.Errorf("Expected CommitId abc1234, got %s", result.CommitId)
+	}
+}
+
+func TestGithubPayloadDeletedBranch(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/feature","deleted":true,"after":"0000000000000000000000000000000000000000","head_commit":null,"repository":{"name":"json-repo"}}`)
+	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "push")
+
+	if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected branch deletion to be ignored, got %v", err)
+	}
+}
+
+func TestGetPayloadGitlabHeader(t *testing.T) {
+	body := []byte(`{"project":{"name":"gitlab-repo"},"commits":[{"id":"def4567"}]}`)
+	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+
+	result, err := parseRequest(req)
+	if err != nil {
+		t.Fatalf("parseRequest failed: %v", err)
+	}
+
+	if result.Repo != "gitlab-repo" {
+		t.Errorf("Expected Repo gitlab-repo, got %s", result.Repo)
+	}
+}
+
+func TestMatchRef(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		ref      string
+		expected bool
+	}{
+		{nil, "refs/heads/feature/x", true},
+		{[]string{"main"}, "refs/heads/main", true},
+		{[]string{"main"}, "refs/heads/develop", false},
+		{[]string{"release/*"}, "refs/heads/release/1.0", true},
+		{[]string{"release/*"}, "refs/heads/release", false},
+		{[]string{"refs/tags/v*"}, "refs/tags/v1.2", true},
+		{[]string{"main", "develop"}, "refs/heads/develop", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchRef(tt.patterns, tt.ref); got != tt.expected {
+			t.Errorf("matchRef(%v, %s) = %v, expected %v", tt.patterns, tt.ref, got, tt.expected)
+		}
+	}
+}
+
+func TestHandleIgnoredBranch(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["test-repo"] = config.Repo{
+		Folders:  []config.Folder{{Path: "/path/to/repo"}},
+		Branches: []string{"main"},
+	}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	body := []byte(`{"ref":"refs/heads/feature","repository":{"name":"test-repo"}}`)
+	req := httptest.NewRequest("POST", "/wh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "push")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("Expected ignored response, got %q", w.Body.String())
+	}
+}
+
+func TestBranchFolders(t *testing.T) {
+	folders := []config.Folder{
+		{Path: "/any"},
+		{Path: "/staging", Branch: "develop"},
+		{Path: "/production", Branch: "main"},
+	}
+
+	result := branchFolders(folders, "refs/heads/main")
+	if len(result) != 2 || result[0].Path != "/any" || result[1].Path != "/production" {
+		t.Errorf("Expected [/any /production], got %v", result)
+	}
+
+	result = branchFolders(folders, "refs/heads/feature")
+	if len(result) != 1 || result[0].Path != "/any" {
+		t.Errorf("Expected [/any], got %v", result)
+	}
+}
+
+func TestFindRepo(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["acme/api"] = config.Repo{Secret: "full-name"}
+	repos["web"] = config.Repo{Secret: "url", URLs: []string{"https://github.com/acme/web.git"}}
+	repos["docs"] = config.Repo{Secret: "short-name"}
+	h := &handler{repos: repos, urls: urlIndex(repos)}
+
+	tests := []struct {
+		payload  Payload
+		expected string
+		found    bool
+	}{
+		{Payload{Repo: "api", FullName: "acme/api"}, "acme/api", true},
+		{Payload{Repo: "api", FullName: "other-org/api"}, "", false},
+		{Payload{Repo: "web", FullName: "fork/web", URLs: []string{"HTTPS://github.com/acme/web/"}}, "web", true},
+		{Payload{Repo: "web", FullName: "fork/web", URLs: []string{"https://github.com/fork/web.git"}}, "", false},
+		{Payload{Repo: "docs", FullName: "acme/docs"}, "docs", true},
+	}
+
+	for _, tt := range tests {
+		name, repo, found := h.findRepo(&tt.payload)
+		if name != tt.expected || found != tt.found {
+			t.Errorf("findRepo(%+v) = %s, %v, expected %s, %v", tt.payload, name, found, tt.expected, tt.found)
+		}
+		if found && repo.Secret != repos[name].Secret {
+			t.Errorf("findRepo(%+v) returned wrong repository %+v", tt.payload, repo)
+		}
+	}
+}
+
+func TestHandleGithubPing(t *testing.T) {
+	handler := New(make(map[string]config.Repo), 1, 1, &mockPuller{}, nil, "")
+
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{"zen": "Keep it simple.", "hook_id": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "ping")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestGithubEvents(t *testing.T) {
+	tests := []struct {
+		event    string
+		body     string
+		expected Payload
+	}{
+		{
+			"push",
+			`{"ref": "refs/tags/v1.0", "head_commit": {"id": "abc1234"}, "repository": {"name": "repo"}}`,
+			Payload{Event: EventTag, Ref: "refs/tags/v1.0", CommitId: "abc1234"},
+		},
+		{
+			"release",
+			`{"action": "published", "release": {"tag_name": "v1.0", "name": "First"}, "repository": {"name": "repo"}}`,
+			Payload{Event: EventRelease, Ref: "refs/tags/v1.0", Message: "First"},
+		},
+		{
+			"pull_request",
+			`{"action": "closed", "pull_request": {"title": "Feature", "merged": true, "merge_commit_sha": "def4567",
+				"base": {"ref": "main"}, "merged_by": {"login": "reviewer"}}, "repository": {"name": "repo"}}`,
+			Payload{Event: EventMerge, Ref: "refs/heads/main", CommitId: "def4567", Name: "reviewer", Message: "Feature"},
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", tt.event)
+
+		result, err := parseRequest(req)
+		if err != nil {
+			t.Fatalf("%s: parseRequest failed: %v", tt.event, err)
+		}
+
+		if result.Event != tt.expected.Event || result.Ref != tt.expected.Ref || result.CommitId != tt.expected.CommitId ||
+			result.Name != tt.expected.Name || result.Message != tt.expected.Message {
+			t.Errorf("%s: expected %+v, got %+v", tt.event, tt.expected, *result)
+		}
+	}
+
+	for event, body := range map[string]string{
+		"release":      `{"action": "created", "release": {"tag_name": "v1.0"}}`,
+		"pull_request": `{"action": "closed", "pull_request": {"merged": false}}`,
+		"issues":       `{}`,
+	} {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", event)
+
+		if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+			t.Errorf("%s: expected event to be ignored, got %v", event, err)
+		}
+	}
+}
+
+func TestHandleGitlabTagPush(t *testing.T) {
+	body := `{"ref": "refs/tags/v1.0", "checkout_sha": "abc1234", "user_name": "tagger", "project": {"name": "tag-repo"}, "commits": []}`
+
+	for events, expected := range map[string]string{"": "ignored", "tag": "queued"} {
+		repos := make(map[string]config.Repo)
+		repos["tag-repo"] = config.Repo{
+			Folders: []config.Folder{{Path: "/path/to/repo"}},
+		}
+		if events != "" {
+			repo := repos["tag-repo"]
+			repo.Events = []string{events}
+			repos["tag-repo"] = repo
+		}
+		handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gitlab-Event", "Tag Push Hook")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Events %q: expected %s, got %d %s", events, expected, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGitlabEvents(t *testing.T) {
+	tests := []struct {
+		event    string
+		body     string
+		expected Payload
+	}{
+		{
+			"Merge Request Hook",
+			`{"user": {"name": "merger"}, "project": {"name": "repo"}, "object_attributes": {"action": "merge",
+				"title": "Feature", "target_branch": "main", "merge_commit_sha": "abc1234"}}`,
+			Payload{Event: EventMerge, Ref: "refs/heads/main", CommitId: "abc1234", Name: "merger", Message: "Feature"},
+		},
+		{
+			"Release Hook",
+			`{"action": "create", "tag": "v1.0", "name": "First", "project": {"name": "repo"}, "commit": {"id": "def4567"}}`,
+			Payload{Event: EventRelease, Ref: "refs/tags/v1.0", CommitId: "def4567", Message: "First"},
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(tt.body))
+		req.Header.Set("X-Gitlab-Event", tt.event)
+
+		result, err := parseRequest(req)
+		if err != nil {
+			t.Fatalf("%s: parseRequest failed: %v", tt.event, err)
+		}
+
+		if result.Event != tt.expected.Event || result.Ref != tt.expected.Ref || result.CommitId != tt.expected.CommitId ||
+			result.Name != tt.expected.Name || result.Message != tt.expected.Message {
+			t.Errorf("%s: expected %+v, got %+v", tt.event, tt.expected, *result)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{"object_attributes": {"action": "open"}}`))
+	req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+	if _, err := parseRequest(req); !errors.Is(err, errIgnored) {
+		t.Errorf("Expected opened merge request to be ignored, got %v", err)
+	}
+}
+
+func TestHandleRepoRoutes(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["test-repo"] = config.Repo{Folders: []config.Folder{{Path: "/path/to/repo"}}}
+	repos["acme/api"] = config.Repo{Folders: []config.Folder{{Path: "/path/to/api"}}}
+	handler := New(repos, 10, 1, &mockPuller{}, nil, "")
+
+	tests := []struct {
+		target   string
+		event    string
+		body     string
+		expected int
+	}{
+		{"/wh/test-repo", "push", `{"repository": {"name": "test-repo"}}`, http.StatusAccepted},
+		{"/wh/test-repo", "push", `{"repository": {"name": "other-repo"}}`, http.StatusBadRequest},
+		{"/wh/unknown-repo", "push", `{"repository": {"name": "unknown-repo"}}`, http.StatusBadRequest},
+		{"/wh/acme%2Fapi", "push", `{"repository": {"name": "api", "full_name": "acme/api"}}`, http.StatusAccepted},
+		{"/wh/acme%2Fapi", "push", `{"repository": {"name": "api", "full_name": "other-org/api"}}`, http.StatusBadRequest},
+		{"/wh/github/test-repo", "", `{"repository": {"name": "test-repo"}}`, http.StatusAccepted},
+		{"/wh/unknown/test-repo", "", `{"repository": {"name": "test-repo"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.event != "" {
+			req.Header.Set("X-GitHub-Event", tt.event)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.expected {
+			t.Errorf("%s %s: expected status %d, got %d", tt.target, tt.body, tt.expected, w.Code)
+		}
+	}
+}
+
+func TestHandleQueueFull(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["test-repo"] = config.Repo{Folders: []config.Folder{{Path: "/path/to/repo"}}}
+
+	pulled := &blockingPuller{started: make(chan puller.Job), release: make(chan struct{})}
+	defer close(pulled.release)
+	handler := New(repos, 1, 1, pulled, nil, "")
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{"repository": {"name": "test-repo"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "push")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	resp := jobResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", w.Body.String(), err)
+	}
+
+	// the only worker is busy with the first job until it is released
+	job := <-pulled.started
+	if resp.ID == "" || resp.ID != job.ID || resp.Status != "queued" {
+		t.Errorf("Expected queued job %s, got %+v", job.ID, resp)
+	}
+
+	if w = send(); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected second job to be queued, got %d", w.Code)
+	}
+
+	w = send()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
+
+func TestHandleDebouncedRepo(t *testing.T) {
+	repos := make(map[string]config.Repo)
+	repos["slow"] = config.Repo{Debounce: 60, Folders: []config.Folder{{Path: "/srv/slow"}}}
+	repos["fast"] = config.Repo{Folders: []config.Folder{{Path: "/srv/fast"}}}
+
+	pulled := &debouncePuller{pulled: make(chan puller.Job, 1), release: make(chan struct{})}
+	defer close(pulled.release)
+	handler := New(repos, 5, 1, pulled, nil, "")
+
+	send := func(repo string) int {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader(`{"repository": {"name": "`+repo+`"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "push")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// debounced jobs wait for their window without holding the only worker
+	for i := 0; i < 4; i++ {
+		if code := send("slow"); code != http.StatusAccepted {
+			t.Fatalf("Expected debounced push %d to be queued, got %d", i, code)
+		}
+	}
+	if code := send("fast"); code != http.StatusAccepted {
+		t.Fatalf("Expected push of other repository to be queued, got %d", code)
+	}
+
+	select {
+	case job := <-pulled.pulled:
+		if job.Repo != "fast" {
+			t.Errorf("Expected job of fast, got %s", job.Repo)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected job of other repository to be pulled during debounce")
+	}
+}
+
+func TestFinishCoalescedJob(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := queue.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close()
+
+	h := &handler{journal: journal, left: make(map[string]int), lock: &sync.Mutex{}}
+	completed := func(id string) bool {
+		data, _ := os.ReadFile(filepath.Join(dir, "jobs.journal"))
+		return strings.Contains(string(data), `"op":"done","id":"`+id+`"`)
+	}
+
+	// pull serving the job may finish before or after the job returns
+	h.finish("a", 2)
+	h.finish("a", -1)
+	if completed("a") {
+		t.Error("Expected job with a coalesced folder left not to be completed")
+	}
+	h.finish("a", -1)
+	if !completed("a") {
+		t.Error("Expected job to be completed once its coalesced folders are pulled")
+	}
+
+	h.finish("b", -1)
+	if completed("b") {
+		t.Error("Expected job not to be completed before it returns")
+	}
+	h.finish("b", 1)
+	if !completed("b") || len(h.left) != 0 {
+		t.Errorf("Expected job to be completed, %d jobs left", len(h.left))
+	}
+}
+
+// debouncePuller blocks debounced jobs until released and sends other ones into channel
+type debouncePuller struct {
+	pulled  chan puller.Job
+	release chan struct{}
+}
+
+func (d *debouncePuller) Pull(ctx context.Context, job puller.Job) (*puller.Result, error) {
+	if job.Debounce > 0 {
+		<-d.release
+	} else {
+		d.pulled <- job
+	}
+	return &puller.Result{JobID: job.ID}, nil
+}
+
+// blockingPuller reports started jobs and blocks them until released
+type blockingPuller struct {
+	started chan puller.Job
+	release chan struct{}
+}
+
+func (b *blockingPuller) Pull(ctx context.Context, job puller.Job) (*puller.Result, error) {
+	select {
+	case b.started <- job:
+	case <-b.release:
+	}
+	<-b.release
+	return &puller.Result{JobID: job.ID}, nil
+}
+
+// chanPuller sends pulled jobs into channel
+type chanPuller chan puller.Job
+
+func (c chanPuller) Pull(ctx context.Context, job puller.Job) (*puller.Result, error) {
+	c <- job
+	return &puller.Result{JobID: job.ID}, nil
+}
+
+func TestHandleJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, err := queue.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	_ = journal.Accept(puller.Job{ID: "left", Folders: []config.Folder{{Path: "/srv/app"}}})
+	_ = journal.Close()
+
+	journal, err = queue.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+
+	pulled := make(chanPuller, 1)
+	New(make(map[string]config.Repo), 1, 1, pulled, journal, "")
+
+	select {
+	case job := <-pulled:
+		if job.ID != "left" || job.Folders[0].Path != "/srv/app" {
+			t.Errorf("Expected unfinished job to be replayed, got %+v", job)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected unfinished job to be replayed")
+	}
+
+	// completion is recorded after Pull returns
+	for i := 0; i < 100; i++ {
+		data, _ := os.ReadFile(filepath.Join(dir, "jobs.journal"))
+		if strings.Contains(string(data), `"op":"done","id":"left"`) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected replayed job to be completed in journal")
+}
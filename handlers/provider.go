@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"gitwh/config"
+	"hash"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Provider is a webhook source, e.g. a git forge
+type Provider interface {
+	// Name returns short provider name, e.g. "github"
+	Name() string
+	// Detect reports whether request is sent by this provider
+	Detect(r *http.Request, body []byte) bool
+	// Parse converts request into Payload
+	Parse(r *http.Request, body []byte) (*Payload, error)
+	// Verify checks request against secret of the repository it refers to
+	Verify(r *http.Request, body []byte, repo config.Repo) error
+}
+
+// builtinProviders are detected in this order. Gitea family sends X-GitHub-Event as well
+// so it goes first, Azure DevOps is detected by body so it goes last
+var builtinProviders = []Provider{
+	giteaProvider{},
+	gitlabProvider{},
+	bitbucketProvider{},
+	githubProvider{},
+	azureProvider{},
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     []Provider
+)
+
+// Register adds provider to the registry. Registered providers are detected
+// before built-in ones in order of registration
+func Register(p Provider) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry = append(registry, p)
+}
+
+// Providers returns registered and built-in providers in order of detection
+func Providers() []Provider {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	res := make([]Provider, 0, len(registry)+len(builtinProviders))
+	res = append(res, registry...)
+	return append(res, builtinProviders...)
+}
+
+// detectProvider returns provider sent the request, extra providers are tried after registered ones.
+// Requests without provider headers are guessed by content type: JSON is GitLab, anything else is GitHub form post
+func detectProvider(r *http.Request, body []byte, extra ...Provider) Provider {
+	for _, p := range append(Providers(), extra...) {
+		if p.Detect(r, body) {
+			return p
+		}
+	}
+
+	if isJSON(r) {
+		return gitlabProvider{}
+	}
+	return githubProvider{}
+}
+
+// isJSON reports whether request body is sent as application/json
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// formPayload extracts JSON payload from body.
+// Form encoded requests carry payload in "payload" field
+func formPayload(r *http.Request, body []byte) ([]byte, error) {
+	if isJSON(r) {
+		return body, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(form.Get("payload")), nil
+}
+
+// firstHeader returns value of the first non empty header
+func firstHeader(r *http.Request, headers []string) string {
+	for _, header := range headers {
+		if value := r.Header.Get(header); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// verifySignature validates hex encoded HMAC signatures of body against repository secret.
+// SHA-1 signature is used only when SHA-256 one is absent and it's allowed by config
+func verifySignature(repo config.Repo, body []byte, sha256Signature, sha1Signature string) error {
+	if repo.Secret == "" {
+		return nil
+	}
+
+	switch {
+	case sha256Signature != "":
+		if !validSignature(sha256.New, repo.Secret, body, sha256Signature) {
+			return fmt.Errorf("sha256 signature is not valid")
+		}
+		return nil
+	case sha1Signature != "" && repo.AllowSHA1:
+		if !validSignature(sha1.New, repo.Secret, body, sha1Signature) {
+			return fmt.Errorf("sha1 signature is not valid")
+		}
+		return nil
+	}
+	return fmt.Errorf("signature is missing")
+}
+
+// verifyToken compares plain token sent with request against repository secret
+func verifyToken(repo config.Repo, token string) error {
+	if repo.Secret == "" {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(repo.Secret)) != 1 {
+		return fmt.Errorf("secret is not valid. Given value: %s", token)
+	}
+	return nil
+}
+
+// validSignature compares hex encoded HMAC of body with expected one in constant time
+func validSignature(h func() hash.Hash, secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
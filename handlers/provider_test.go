@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"gitwh/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type customProvider struct{}
+
+func (customProvider) Name() string {
+	return "custom"
+}
+
+func (customProvider) Detect(r *http.Request, _ []byte) bool {
+	return r.Header.Get("X-Custom-Event") != ""
+}
+
+func (customProvider) Parse(r *http.Request, _ []byte) (*Payload, error) {
+	return &Payload{Repo: r.Header.Get("X-Custom-Repo"), Ref: "refs/heads/main"}, nil
+}
+
+func (customProvider) Verify(r *http.Request, _ []byte, repo config.Repo) error {
+	return verifyToken(repo, r.Header.Get("X-Custom-Token"))
+}
+
+func TestRegisterProvider(t *testing.T) {
+	Register(customProvider{})
+	defer func() {
+		registry = nil
+	}()
+
+	providers := Providers()
+	if len(providers) != len(builtinProviders)+1 || providers[0].Name() != "custom" {
+		t.Fatalf("Expected custom provider to go first, got %d providers", len(providers))
+	}
+
+	repos := make(map[string]config.Repo)
+	repos["custom-repo"] = config.Repo{
+		Secret:  "token",
+		Folders: []config.Folder{{Path: "/path/to/repo"}},
+	}
+	handler := New(repos, 1, 1, &mockPuller{}, nil, "")
+
+	for token, expected := range map[string]int{"token": http.StatusAccepted, "wrong": http.StatusBadRequest} {
+		req := httptest.NewRequest("POST", "/wh", strings.NewReader("{}"))
+		req.Header.Set("X-Custom-Event", "push")
+		req.Header.Set("X-Custom-Repo", "custom-repo")
+		req.Header.Set("X-Custom-Token", token)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Token %s: expected status %d, got %d", token, expected, w.Code)
+		}
+	}
+}
+
+func TestDetectProviderFallback(t *testing.T) {
+	req := httptest.NewRequest("POST", "/wh", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	if p := detectProvider(req, []byte("{}")); p.Name() != "gitlab" {
+		t.Errorf("Expected gitlab for JSON without headers, got %s", p.Name())
+	}
+
+	req = httptest.NewRequest("POST", "/wh", strings.NewReader("payload=%7B%7D"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p := detectProvider(req, []byte("payload=%7B%7D")); p.Name() != "github" {
+		t.Errorf("Expected github for form without headers, got %s", p.Name())
+	}
+}
+
+func TestVerifySignatureLegacySHA1(t *testing.T) {
+	body := []byte("payload")
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	if err := verifySignature(config.Repo{Secret: "secret"}, body, "", signature); err == nil {
+		t.Error("Expected sha1 signature to be rejected unless allowed")
+	}
+
+	if err := verifySignature(config.Repo{Secret: "secret", AllowSHA1: true}, body, "", signature); err != nil {
+		t.Errorf("Expected sha1 signature to be accepted, got %v", err)
+	}
+
+	if err := verifySignature(config.Repo{Secret: "secret", AllowSHA1: true}, body, "", "00"); err == nil {
+		t.Error("Expected invalid sha1 signature to be rejected")
+	}
+
+	if err := verifySignature(config.Repo{}, body, "", ""); err != nil {
+		t.Errorf("Expected repository without secret to accept anything, got %v", err)
+	}
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"gitwh/config"
 	"gitwh/handlers"
+	"gitwh/puller"
 	"gitwh/puller/git"
+	"gitwh/queue"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		os.Exit(rollbackCommand(os.Args[2:]))
+	}
 
 	configPath := flag.String("config", "/etc/gitwh.yaml", "Configuration file path")
 	flag.Parse()
@@ -22,15 +29,51 @@ func main() {
 	}
 
 	fmt.Printf("Webhook Server, config - %s\n", *configPath)
-	fmt.Printf("%d repo(s), BufferSize: %d, Timeout: %d\n", len(cfg.Repos), cfg.BufferSize, cfg.Timeout)
+	fmt.Printf("%d repo(s), BufferSize: %d, Timeout: %d, Workers: %d\n", len(cfg.Repos), cfg.BufferSize, cfg.Timeout, cfg.Workers)
+
+	var journal *queue.Journal
+	if cfg.StateDir != "" {
+		journal, err = queue.Open(cfg.StateDir)
+		if err != nil {
+			log.Fatalf("Failed to open job journal: %v", err)
+		}
+		fmt.Printf("Job journal in %s, %d unfinished job(s)\n", cfg.StateDir, len(journal.Pending()))
+	}
 
-	http.Handle("/", newHandler(cfg))
+	p := git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers)
+	cloneMissing(cfg, p)
+
+	http.Handle("/", newHandler(cfg, p, journal))
 
 	if err := http.ListenAndServe(cfg.Listen, nil); err != nil {
 		fmt.Printf("Failed to ListenAndServe : %v", err)
 	}
 }
 
-func newHandler(cfg *config.Config) http.Handler {
-	return handlers.New(cfg.Repos, cfg.BufferSize, git.New(cfg.Timeout))
+// cloneMissing clones folders with url which do not exist or are not git repositories yet,
+// atomic folders are deployed only if their repository is missing
+func cloneMissing(cfg *config.Config, p puller.Puller) {
+	for name, repo := range cfg.Repos {
+		job := puller.Job{ID: "startup-" + name, Repo: name, Workers: repo.Workers}
+		for _, folder := range repo.Folders {
+			folder = repo.ResolveFolder(folder)
+			if folder.URL != "" && !git.IsRepo(git.RepoPath(folder)) {
+				job.Folders = append(job.Folders, folder)
+			}
+		}
+
+		if len(job.Folders) == 0 {
+			continue
+		}
+
+		go func(job puller.Job) {
+			if _, err := p.Pull(context.Background(), job); err != nil {
+				fmt.Printf("Failed to clone %s: %v\n", job.Repo, err)
+			}
+		}(job)
+	}
+}
+
+func newHandler(cfg *config.Config, p puller.Puller, journal *queue.Journal) http.Handler {
+	return handlers.New(cfg.Repos, cfg.BufferSize, cfg.Workers, p, journal, cfg.AdminToken)
 }
@@ -15,12 +15,12 @@ func TestNewHandler(t *testing.T) {
 		Repos: map[string]config.Repo{
 			"test-repo": {
 				Secret:  "secret",
-				Folders: []string{"/path/to/repo"},
+				Folders: []config.Folder{{Path: "/path/to/repo"}},
 			},
 		},
 	}
 	
-	handler := newHandler(cfg)
+	handler := newHandler(cfg, git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers), nil)
 	
 	if handler == nil {
 		t.Error("Expected handler to be created")
@@ -39,7 +39,7 @@ func TestNewHandlerWithEmptyRepos(t *testing.T) {
 		Repos:      make(map[string]config.Repo),
 	}
 	
-	handler := newHandler(cfg)
+	handler := newHandler(cfg, git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers), nil)
 	
 	if handler == nil {
 		t.Error("Expected handler to be created even with empty repos")
@@ -54,14 +54,14 @@ func TestNewHandlerIntegration(t *testing.T) {
 		Repos: map[string]config.Repo{
 			"integration-repo": {
 				Secret:  "integration-secret",
-				Folders: []string{"/tmp/integration"},
+				Folders: []config.Folder{{Path: "/tmp/integration"}},
 			},
 		},
 	}
 	
-	handler := newHandler(cfg)
+	handler := newHandler(cfg, git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers), nil)
 	
-	gitPuller := git.New(cfg.Timeout)
+	gitPuller := git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers)
 	if gitPuller == nil {
 		t.Error("Expected git puller to be created")
 	}
@@ -0,0 +1,334 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout of atomic deploy folder
+const (
+	repoDir     = "repo"
+	releasesDir = "releases"
+	currentLink = "current"
+)
+
+const defaultKeepReleases = 5
+
+// releaseTimeFormat sorts release names by time of deploy
+const releaseTimeFormat = "20060102150405"
+
+// releaseName returns release directory name of commit deployed at t
+func releaseName(t time.Time, commit string) string {
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return t.UTC().Format(releaseTimeFormat) + "-" + commit
+}
+
+// RepoPath returns path of git repository of folder, atomic folders keep it in repo subdirectory
+func RepoPath(folder config.Folder) string {
+	if folder.Atomic {
+		return filepath.Join(folder.Path, repoDir)
+	}
+	return folder.Path
+}
+
+// Releases returns release names of atomic deploy folder from the oldest to the newest
+func Releases(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(path, releasesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CurrentRelease returns release name the current symlink of atomic deploy folder points to
+func CurrentRelease(path string) string {
+	target, err := os.Readlink(filepath.Join(path, currentLink))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// switchRelease atomically points current symlink to the release
+func switchRelease(path, release string) error {
+	tmp := filepath.Join(path, currentLink+".tmp")
+	_ = os.Remove(tmp)
+
+	if err := os.Symlink(filepath.Join(releasesDir, release), tmp); err != nil {
+		return fmt.Errorf("failed to link release %s: %v", release, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(path, currentLink)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to switch to release %s: %v", release, err)
+	}
+	return nil
+}
+
+// removeRelease removes release directory and its worktree record
+func removeRelease(ctx context.Context, path, release string) error {
+	if err := os.RemoveAll(filepath.Join(path, releasesDir, release)); err != nil {
+		return err
+	}
+	return gitCommands(ctx, filepath.Join(path, repoDir), [][]string{{"worktree", "prune"}}, nil, nil)
+}
+
+// pruneReleases removes the oldest releases except the current one, keep releases are left
+func pruneReleases(ctx context.Context, folder config.Folder) {
+	keep := folder.KeepReleases
+	if keep <= 0 {
+		keep = defaultKeepReleases
+	}
+
+	releases, err := Releases(folder.Path)
+	if err != nil {
+		fmt.Printf("[%s] Failed to list releases : %v\n", folder.Path, err)
+		return
+	}
+
+	current := CurrentRelease(folder.Path)
+	for i := 0; i < len(releases)-keep; i++ {
+		if releases[i] == current {
+			continue
+		}
+		if err := removeRelease(ctx, folder.Path, releases[i]); err != nil {
+			fmt.Printf("[%s] Failed to remove release %s : %v\n", folder.Path, releases[i], err)
+		}
+	}
+}
+
+// fetchTarget returns commands fetching the folder branch or the remote and the revision to be deployed
+func fetchTarget(folder config.Folder, commit string) ([]string, string) {
+	remote := folder.Remote
+	if remote == "" {
+		remote = defaultRemote
+	}
+
+	fetch := []string{"fetch", remote}
+	target := "@{upstream}"
+	if folder.Branch != "" {
+		fetch = append(fetch, folder.Branch)
+		target = "FETCH_HEAD"
+	}
+
+	if commit != "" {
+		target = commit
+	}
+	return fetch, target
+}
+
+// deployRelease checks the commit out into new release, runs hooks in it and switches current symlink to it.
+// Failed release is removed and current one stays untouched
+func (p *simplePuller) deployRelease(ctx context.Context, folder config.Folder, job puller.Job) puller.FolderResult {
+	start := time.Now()
+	res := puller.FolderResult{Path: folder.Path}
+
+	current := filepath.Join(folder.Path, currentLink)
+	if CurrentRelease(folder.Path) != "" {
+		res.OldHead = head(ctx, current)
+		if err := runHooks(ctx, "pre_pull", folder.PrePull, current, folder, job, &res); err != nil {
+			res.Err = err
+			res.NewHead = res.OldHead
+			res.Duration = time.Since(start)
+			return res
+		}
+	}
+
+	oldRelease := CurrentRelease(folder.Path)
+
+	var release string
+	var err error
+	// kept release is an existing one switched to by rollback, it is not removed on failure
+	kept := false
+	if job.Rollback != "" {
+		release, kept, err = p.rollbackRelease(ctx, folder, job.Rollback, &res)
+	} else {
+		release, err = p.checkoutRelease(ctx, folder, updateCommit(job), &res)
+	}
+	if err == nil && release == "" {
+		fmt.Printf("[%s] Commit %s is already deployed\n", folder.Path, res.NewHead)
+		res.Changed = []string{}
+		res.Duration = time.Since(start)
+		return res
+	}
+
+	dir := filepath.Join(folder.Path, releasesDir, release)
+	if err == nil && res.OldHead != "" {
+		changed, diffErr := changedFiles(ctx, filepath.Join(folder.Path, repoDir), res.OldHead, res.NewHead)
+		if diffErr != nil {
+			// changes are unknown, all post pull hooks run
+			fmt.Printf("[%s] Changed files are unknown : %v\n", folder.Path, diffErr)
+		}
+		res.Changed = changed
+	}
+	if err == nil {
+		err = runHooks(ctx, "post_pull", folder.PostPull, dir, folder, job, &res)
+	}
+	if err == nil {
+		err = switchRelease(folder.Path, release)
+	}
+
+	if err != nil {
+		res.Err = err
+		res.NewHead = res.OldHead
+		if release != "" && !kept {
+			if rmErr := removeRelease(context.Background(), folder.Path, release); rmErr != nil {
+				fmt.Printf("[%s] Failed to remove release %s : %v\n", folder.Path, release, rmErr)
+			}
+		}
+		fmt.Printf("[%s] Release failed : %v\n", folder.Path, err)
+	} else {
+		fmt.Printf("[%s] Released %s in %.3f\n", folder.Path, release, time.Since(start).Seconds())
+		now := time.Now()
+		recordDeploy(folder, Deploy{Time: now, Commit: res.OldHead, Release: oldRelease},
+			Deploy{Time: now, Commit: res.NewHead, Release: release, Job: job.ID, Rollback: job.Rollback != ""})
+		pruneReleases(ctx, folder)
+	}
+
+	res.Duration = time.Since(start)
+	return res
+}
+
+// rollbackRelease returns release to roll back to. Existing release of the target is kept,
+// otherwise the target commit is checked out into new release
+func (p *simplePuller) rollbackRelease(ctx context.Context, folder config.Folder, target string, res *puller.FolderResult) (string, bool, error) {
+	deploy, err := rollbackTarget(ctx, folder, target)
+	if err != nil {
+		return "", false, fmt.Errorf("rollback: %v", err)
+	}
+
+	if deploy.Release == "" {
+		deploy.Release = findRelease(folder.Path, deploy.Commit)
+		if deploy.Release == "" {
+			fmt.Printf("[%s] Rolling back to new release of %s\n", folder.Path, deploy.Commit)
+			release, err := p.checkoutRelease(ctx, folder, deploy.Commit, res)
+			return release, false, err
+		}
+	}
+
+	dir := filepath.Join(folder.Path, releasesDir, deploy.Release)
+	if _, err := os.Stat(dir); err != nil {
+		if deploy.Commit == "" {
+			return "", false, fmt.Errorf("rollback: %v", err)
+		}
+		fmt.Printf("[%s] Release %s is removed, rolling back to new release of %s\n", folder.Path, deploy.Release, deploy.Commit)
+		release, err := p.checkoutRelease(ctx, folder, deploy.Commit, res)
+		return release, false, err
+	}
+
+	fmt.Printf("[%s] Rolling back to release %s\n", folder.Path, deploy.Release)
+	res.NewHead = head(ctx, dir)
+	if deploy.Release == CurrentRelease(folder.Path) {
+		return "", false, nil
+	}
+	return deploy.Release, true, nil
+}
+
+// findRelease returns the newest release of commit, empty string if there is none
+func findRelease(path, commit string) string {
+	releases, _ := Releases(path)
+	suffix := "-" + commit
+	if len(commit) > 12 {
+		suffix = "-" + commit[:12]
+	}
+
+	for i := len(releases) - 1; i >= 0; i-- {
+		if strings.HasSuffix(releases[i], suffix) {
+			return releases[i]
+		}
+	}
+	return ""
+}
+
+// checkoutRelease checks the commit out into new release, failed attempts are retried by retry policy
+// of folder. Empty release is returned when the commit is deployed already
+func (p *simplePuller) checkoutRelease(ctx context.Context, folder config.Folder, commit string, res *puller.FolderResult) (string, error) {
+	var release string
+	err := p.retry(ctx, folder, res, func(ctx context.Context) error {
+		var err error
+		release, err = p.checkoutReleaseOnce(ctx, folder, commit, res)
+		if err != nil && release != "" {
+			// partial release is not reused by the next attempt
+			if rmErr := removeRelease(context.Background(), folder.Path, release); rmErr != nil {
+				fmt.Printf("[%s] Failed to remove release %s : %v\n", folder.Path, release, rmErr)
+			}
+			release = ""
+		}
+		return err
+	})
+	return release, err
+}
+
+// checkoutReleaseOnce clones or fetches repository of folder and checks the commit out into new release
+func (p *simplePuller) checkoutReleaseOnce(ctx context.Context, folder config.Folder, commit string, res *puller.FolderResult) (string, error) {
+	var stdout, stderr bytes.Buffer
+	defer func() {
+		res.Stdout = stdout.String()
+		res.Stderr = stderr.String()
+	}()
+
+	repo := folder
+	repo.Path = RepoPath(folder)
+
+	if commit != "" && !puller.IsCommitID(commit) {
+		return "", fmt.Errorf("invalid commit %q", commit)
+	}
+
+	fetch, target := fetchTarget(folder, commit)
+	var err error
+	if !IsRepo(repo.Path) {
+		if repo.URL == "" {
+			return "", fmt.Errorf("%s is not a git repository and url is not set", repo.Path)
+		}
+		fmt.Printf("[%s] Cloning %s\n", repo.Path, repo.URL)
+		err = p.clone(ctx, repo, &stdout, &stderr)
+	}
+	if err == nil {
+		err = gitCommands(ctx, repo.Path, [][]string{fetch}, &stdout, &stderr)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var sha bytes.Buffer
+	if err := gitCommands(ctx, repo.Path, [][]string{{"rev-parse", "--verify", "--end-of-options", target + "^{commit}"}}, &sha, &stderr); err != nil {
+		return "", err
+	}
+	res.NewHead = strings.TrimSpace(sha.String())
+	if res.NewHead == res.OldHead {
+		return "", nil
+	}
+
+	release := releaseName(time.Now(), res.NewHead)
+	dir := filepath.Join(folder.Path, releasesDir, release)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create releases dir: %v", err)
+	}
+
+	cmds := [][]string{{"worktree", "add", "--detach", dir, res.NewHead}}
+	if err := gitCommands(ctx, repo.Path, cmds, &stdout, &stderr); err != nil {
+		return "", err
+	}
+
+	return release, gitCommands(ctx, dir, syncCommands(folder), &stdout, &stderr)
+}
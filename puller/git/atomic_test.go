@@ -0,0 +1,133 @@
+package git
+
+import (
+	"context"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseName(t *testing.T) {
+	at := time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)
+	if name := releaseName(at, "0123456789abcdef0123"); name != "20240305102030-0123456789ab" {
+		t.Errorf("Expected release name with short commit, got %s", name)
+	}
+}
+
+func TestFetchTarget(t *testing.T) {
+	fetch, target := fetchTarget(config.Folder{Branch: "main"}, "")
+	if strings.Join(fetch, " ") != "fetch origin main" || target != "FETCH_HEAD" {
+		t.Errorf("Expected fetch of branch, got %v %s", fetch, target)
+	}
+
+	fetch, target = fetchTarget(config.Folder{Remote: "upstream"}, "abc")
+	if strings.Join(fetch, " ") != "fetch upstream" || target != "abc" {
+		t.Errorf("Expected fetch of remote and commit target, got %v %s", fetch, target)
+	}
+}
+
+func TestRepoPath(t *testing.T) {
+	if path := RepoPath(config.Folder{Path: "/srv/site", Atomic: true}); path != "/srv/site/repo" {
+		t.Errorf("Expected repository of atomic folder in repo dir, got %s", path)
+	}
+	if path := RepoPath(config.Folder{Path: "/srv/app"}); path != "/srv/app" {
+		t.Errorf("Expected folder path to be repository, got %s", path)
+	}
+}
+
+// commitFile commits file with content into repository
+func commitFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", name)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+func TestDeployRelease(t *testing.T) {
+	origin, _ := newOrigin(t)
+	root := filepath.Join(t.TempDir(), "site")
+
+	folder := config.Folder{
+		Path:         root,
+		Branch:       "main",
+		Atomic:       true,
+		KeepReleases: 2,
+		Clone:        config.Clone{URL: origin},
+		Hooks:        config.Hooks{PostPull: []config.Hook{{Command: "cp index.html build.html"}}},
+	}
+	p := New(10, 0, 2).(*simplePuller)
+
+	var deployed []string
+	for i, content := range []string{"v1", "v2", "v3"} {
+		commit := commitFile(t, origin, "index.html", content)
+
+		res := p.pullPath(context.Background(), folder, puller.Job{CommitId: commit})
+		if res.Err != nil {
+			t.Fatalf("Expected release %d to succeed, got %v\n%s", i, res.Err, res.Stderr)
+		}
+		if res.NewHead != commit {
+			t.Errorf("Expected %s deployed, got %s", commit, res.NewHead)
+		}
+
+		data, err := os.ReadFile(filepath.Join(root, currentLink, "build.html"))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected current release built from %s, got %q, %v", content, data, err)
+		}
+
+		deployed = append(deployed, CurrentRelease(root))
+		time.Sleep(time.Second)
+	}
+
+	releases, err := Releases(root)
+	if err != nil {
+		t.Fatalf("Failed to list releases: %v", err)
+	}
+	if len(releases) != 2 || releases[0] != deployed[1] || releases[1] != deployed[2] {
+		t.Errorf("Expected last 2 releases %v kept, got %v", deployed[1:], releases)
+	}
+
+	// same commit is not released again
+	res := p.pullPath(context.Background(), folder, puller.Job{})
+	if res.Err != nil || CurrentRelease(root) != deployed[2] {
+		t.Errorf("Expected current release to stay %s, got %s, %v", deployed[2], CurrentRelease(root), res.Err)
+	}
+}
+
+func TestDeployReleaseHookFailure(t *testing.T) {
+	origin, _ := newOrigin(t)
+	root := filepath.Join(t.TempDir(), "site")
+
+	folder := config.Folder{Path: root, Branch: "main", Atomic: true, Clone: config.Clone{URL: origin}}
+	p := New(10, 0, 2).(*simplePuller)
+
+	good := commitFile(t, origin, "index.html", "good")
+	if res := p.pullPath(context.Background(), folder, puller.Job{CommitId: good}); res.Err != nil {
+		t.Fatalf("Expected release to succeed, got %v", res.Err)
+	}
+	current := CurrentRelease(root)
+
+	folder.PostPull = []config.Hook{{Command: "exit 1"}}
+	bad := commitFile(t, origin, "index.html", "bad")
+	res := p.pullPath(context.Background(), folder, puller.Job{CommitId: bad})
+	if res.Err == nil {
+		t.Fatal("Expected release to fail")
+	}
+
+	if CurrentRelease(root) != current {
+		t.Errorf("Expected current release %s to stay, got %s", current, CurrentRelease(root))
+	}
+	if releases, _ := Releases(root); len(releases) != 1 {
+		t.Errorf("Expected failed release to be removed, got %v", releases)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, currentLink, "index.html")); string(data) != "good" {
+		t.Errorf("Expected good release to be served, got %q", data)
+	}
+}
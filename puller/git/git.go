@@ -1,25 +1,69 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"gitwh/config"
 	"gitwh/puller"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const defaultGitTimeout = 10
+const defaultCloneTimeout = 600
+const defaultRemote = "origin"
+const defaultWorkers = 4
+
+// pendingPull is a folder update waiting for debounce window or running pull of the folder.
+// job is the latest of coalesced jobs
+type pendingPull struct {
+	folder config.Folder
+	job    puller.Job
+	jobs   []string
+	// done is closed when pull is finished and result is set
+	done   chan struct{}
+	result puller.FolderResult
+}
 
 type simplePuller struct {
-	mutexes    map[string]*sync.Mutex
-	lock       *sync.Mutex
-	gitTimeout int
+	mutexes map[string]*sync.Mutex
+	pending map[string]*pendingPull
+	lock    *sync.Mutex
+	// workers limits number of folders pulled at once, repoWorkers limits it per repository
+	workers     chan struct{}
+	repoWorkers map[string]chan struct{}
+	gitTimeout  int
+	// cloneTimeout limits attempts which clone missing folder
+	cloneTimeout int
 }
 
-// New creates simple gitpuller ( now with mutex per dir )
-func New(timeout int) puller.Puller {
-	return &simplePuller{mutexes: make(map[string]*sync.Mutex), lock: &sync.Mutex{}, gitTimeout: timeout}
+// New creates simple gitpuller ( now with mutex per dir ) pulling at most workers folders at once,
+// defaultWorkers if it is not positive. Missing folders are cloned within cloneTimeout,
+// defaultCloneTimeout if it is not positive
+func New(timeout int, cloneTimeout int, workers int) puller.Puller {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if cloneTimeout <= 0 {
+		cloneTimeout = defaultCloneTimeout
+	}
+
+	return &simplePuller{
+		mutexes:      make(map[string]*sync.Mutex),
+		pending:      make(map[string]*pendingPull),
+		lock:         &sync.Mutex{},
+		workers:      make(chan struct{}, workers),
+		repoWorkers:  make(map[string]chan struct{}),
+		gitTimeout:   timeout,
+		cloneTimeout: cloneTimeout,
+	}
 }
 
 func (p *simplePuller) getMutex(path string) *sync.Mutex {
@@ -32,37 +76,510 @@ func (p *simplePuller) getMutex(path string) *sync.Mutex {
 	return p.mutexes[path]
 }
 
-func (p *simplePuller) mutexedPull(paths []string) {
-	if paths == nil {
-		fmt.Printf("mutexedPull: empty path")
-		return
+// getRepoWorkers returns worker slots of repository, nil if repository is not limited
+func (p *simplePuller) getRepoWorkers(repo string, limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
 	}
-	for _, path := range paths {
-		p.pullPath(path)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.repoWorkers[repo]; !ok {
+		p.repoWorkers[repo] = make(chan struct{}, limit)
 	}
+	return p.repoWorkers[repo]
 }
 
-func (p *simplePuller) pullPath(path string) {
+// acquireWorker waits for free worker slot of repository and global one, returned func releases them
+func (p *simplePuller) acquireWorker(ctx context.Context, job puller.Job) (func(), error) {
+	repoWorkers := p.getRepoWorkers(job.Repo, job.Workers)
+	if repoWorkers != nil {
+		select {
+		case repoWorkers <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	case <-ctx.Done():
+		if repoWorkers != nil {
+			<-repoWorkers
+		}
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-p.workers
+		if repoWorkers != nil {
+			<-repoWorkers
+		}
+	}, nil
+}
+
+// schedule queues folder update. While folder has a pending update the trigger is merged into it,
+// so any number of triggers during debounce window or running pull results in a single follow-up pull.
+// Pull started by the first trigger runs with its context, nil is returned for merged triggers
+func (p *simplePuller) schedule(ctx context.Context, folder config.Folder, job puller.Job) *pendingPull {
+	p.lock.Lock()
+	if pending, ok := p.pending[folder.Path]; ok {
+		pending.folder = folder
+		pending.job = job
+		pending.jobs = append(pending.jobs, job.ID)
+		p.lock.Unlock()
+		fmt.Printf("[%s] Job %s coalesced with pending pull\n", folder.Path, job.ID)
+		return nil
+	}
+	pending := &pendingPull{folder: folder, job: job, jobs: []string{job.ID}, done: make(chan struct{})}
+	p.pending[folder.Path] = pending
+	p.lock.Unlock()
+
+	go p.mutexedPull(ctx, folder.Path, job)
+	return pending
+}
+
+// mutexedPull waits for debounce window, running pull of the folder and free worker,
+// then pulls by the latest pending job. Triggers are coalesced until the pull is started
+func (p *simplePuller) mutexedPull(ctx context.Context, path string, job puller.Job) {
+	select {
+	case <-time.After(job.Debounce):
+	case <-ctx.Done():
+	}
+
 	m := p.getMutex(path)
 	m.Lock()
 	defer m.Unlock()
 
-	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.gitTimeout)*time.Second)
-	defer cancel()
+	release, err := p.acquireWorker(ctx, job)
+	if err == nil {
+		defer release()
+	}
 
-	cmd := exec.CommandContext(ctx, "git", "pull")
-	cmd.Dir = path
+	p.lock.Lock()
+	pending := p.pending[path]
+	delete(p.pending, path)
+	p.lock.Unlock()
+
+	defer close(pending.done)
 
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("PullPath %s: Git pull returned error : %v\n", path, err)
+	if err := ctx.Err(); err != nil {
+		pending.result = puller.FolderResult{Path: path, Err: err, Jobs: pending.jobs}
 		return
 	}
 
-	fmt.Printf("[%s] Git pull done in %.3f\n", path, time.Now().Sub(start).Seconds())
+	if len(pending.jobs) > 1 {
+		fmt.Printf("[%s] Pulling for jobs %s\n", path, strings.Join(pending.jobs, ", "))
+	}
+	pending.result = p.pullPath(ctx, pending.folder, pending.job)
+	pending.result.Jobs = pending.jobs
 }
 
-func (p *simplePuller) Pull(path []string) error {
-	go p.mutexedPull(path)
+// updateCommit returns commit folders of job are brought to. Tagged commits need not be on the branch of folder,
+// so tag and release jobs update folders to the head of their branch
+func updateCommit(job puller.Job) string {
+	if strings.HasPrefix(job.Ref, "refs/tags/") {
+		return ""
+	}
+	return job.CommitId
+}
+
+// updateCommands returns git commands bringing folder to the commit by its update strategy,
+// followed by submodules and LFS synchronization and clean if they are enabled.
+// By default folders without branch are updated by plain git pull and folders with branch
+// are fast-forwarded to the commit. Folders without branch are reset to the current branch of remote
+func updateCommands(folder config.Folder, commit string, current string) ([][]string, error) {
+	if commit != "" && !puller.IsCommitID(commit) {
+		return nil, fmt.Errorf("invalid commit %q", commit)
+	}
+
+	strategy := folder.Strategy
+	if strategy == "" {
+		strategy = config.StrategyPull
+		if folder.Branch != "" {
+			strategy = config.StrategyFFOnly
+		}
+	}
+
+	var cmds [][]string
+	var err error
+	if folder.Branch == "" {
+		cmds, err = pullCommands(folder, strategy, current)
+	} else {
+		cmds, err = branchCommands(folder, strategy, commit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cmds = append(cmds, syncCommands(folder)...)
+
+	if folder.Clean {
+		clean := []string{"clean", "-fdx"}
+		for _, pattern := range folder.CleanExclude {
+			clean = append(clean, "-e", pattern)
+		}
+		cmds = append(cmds, clean)
+	}
+	return cmds, nil
+}
+
+// pullCommands updates current branch of folder from its upstream, reset strategy resets it
+// to the branch of the same name of folder remote
+func pullCommands(folder config.Folder, strategy string, current string) ([][]string, error) {
+	var pull []string
+	switch strategy {
+	case config.StrategyPull:
+		pull = []string{"pull"}
+	case config.StrategyFFOnly:
+		pull = []string{"pull", "--ff-only"}
+	case config.StrategyRebase:
+		pull = []string{"pull", "--rebase"}
+	case config.StrategyReset:
+		remote := folder.Remote
+		if remote == "" {
+			remote = defaultRemote
+		}
+		if current == "" {
+			return nil, fmt.Errorf("reset needs a branch checked out")
+		}
+		return [][]string{
+			{"fetch", remote},
+			{"reset", "--hard", remote + "/" + current},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown update strategy %s", strategy)
+	}
+
+	if folder.Remote != "" {
+		pull = append(pull, folder.Remote)
+	}
+	return [][]string{pull}, nil
+}
+
+// branchCommands checks branch of folder out and brings it to the commit, the fetched head if commit is empty
+func branchCommands(folder config.Folder, strategy string, commit string) ([][]string, error) {
+	remote := folder.Remote
+	if remote == "" {
+		remote = defaultRemote
+	}
+
+	target := commit
+	if target == "" {
+		target = "FETCH_HEAD"
+	}
+
+	var update []string
+	switch strategy {
+	case config.StrategyPull:
+		update = []string{"merge", "--end-of-options", target}
+	case config.StrategyFFOnly:
+		update = []string{"merge", "--ff-only", "--end-of-options", target}
+	case config.StrategyRebase:
+		update = []string{"rebase", "--end-of-options", target}
+	case config.StrategyReset:
+		if commit == "" {
+			target = remote + "/" + folder.Branch
+		}
+		return [][]string{
+			{"fetch", remote, folder.Branch},
+			{"checkout", "-f", folder.Branch},
+			{"reset", "--hard", target},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown update strategy %s", strategy)
+	}
+
+	return [][]string{
+		{"fetch", remote, folder.Branch},
+		{"checkout", folder.Branch},
+		update,
+	}, nil
+}
+
+// gitCommands runs git commands in dir one by one, stops on the first failed one
+func gitCommands(ctx context.Context, dir string, cmds [][]string, stdout, stderr io.Writer) error {
+	for _, args := range cmds {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		// transport processes left by killed git, e.g. ssh, must not hold its output open
+		cmd.WaitDelay = time.Second
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+		}
+	}
 	return nil
 }
+
+// IsRepo reports whether path is a root of git working tree
+func IsRepo(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ".git"))
+	return err == nil
+}
+
+// cloneCommand returns git clone of folder url into its path
+func cloneCommand(folder config.Folder) []string {
+	clone := []string{"clone"}
+	if folder.Depth > 0 {
+		clone = append(clone, "--depth", strconv.Itoa(folder.Depth))
+	}
+	if folder.Branch != "" {
+		clone = append(clone, "--branch", folder.Branch)
+	}
+	if folder.SingleBranch {
+		clone = append(clone, "--single-branch")
+	}
+	if folder.Remote != "" {
+		clone = append(clone, "--origin", folder.Remote)
+	}
+	return append(clone, "--", folder.URL, folder.Path)
+}
+
+// changedFiles returns files changed between commits, empty non nil list if there are no changes
+func changedFiles(ctx context.Context, path, from, to string) ([]string, error) {
+	if from == to {
+		return []string{}, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "-z", from, to)
+	cmd.Dir = path
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --name-only %s %s: %v", from, to, err)
+	}
+
+	changed := []string{}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			changed = append(changed, name)
+		}
+	}
+	return changed, nil
+}
+
+// currentBranch returns branch checked out in path
+func currentBranch(ctx context.Context, path string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "symbolic-ref", "--short", "HEAD")
+	cmd.Dir = path
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git symbolic-ref --short HEAD: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// head returns commit checked out in path, empty string if it is not a git repository
+func head(ctx context.Context, path string) string {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = path
+
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// syncCommands returns commands synchronizing submodules and LFS objects of folder if they are enabled
+func syncCommands(folder config.Folder) [][]string {
+	var cmds [][]string
+	if folder.Submodules {
+		cmds = append(cmds,
+			[]string{"submodule", "sync", "--recursive"},
+			[]string{"submodule", "update", "--init", "--recursive"},
+		)
+	}
+	if folder.LFS {
+		cmds = append(cmds, []string{"lfs", "pull"})
+	}
+	return cmds
+}
+
+// reset rolls folder back to the target commit within git timeout
+func (p *simplePuller) reset(ctx context.Context, folder config.Folder, target string, res *puller.FolderResult) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.gitTimeout)*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	deploy, err := rollbackTarget(ctx, folder, target)
+	if err == nil {
+		fmt.Printf("[%s] Rolling back to %s\n", folder.Path, deploy.Commit)
+		cmds := append([][]string{{"reset", "--hard", deploy.Commit}}, syncCommands(folder)...)
+		err = gitCommands(ctx, folder.Path, cmds, &stdout, &stderr)
+	}
+	if err != nil {
+		res.Err = fmt.Errorf("rollback: %v", err)
+		fmt.Printf("PullPath %s: %v\n%s", folder.Path, res.Err, stderr.String())
+	}
+
+	res.NewHead = head(ctx, folder.Path)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+}
+
+// clone clones folder url into temporary directory beside its path and moves it to the path on success,
+// so interrupted clone leaves no partial repository behind. Parent directories are created
+func (p *simplePuller) clone(ctx context.Context, folder config.Folder, stdout, stderr io.Writer) error {
+	parent := filepath.Dir(folder.Path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", parent, err)
+	}
+
+	// clones interrupted by crash are left behind, they are never resumed
+	prefix := "." + filepath.Base(folder.Path) + ".clone-"
+	leftovers, _ := filepath.Glob(filepath.Join(parent, prefix+"*"))
+	for _, leftover := range leftovers {
+		_ = os.RemoveAll(leftover)
+	}
+
+	tmp, err := os.MkdirTemp(parent, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to create clone dir: %v", err)
+	}
+
+	target := folder
+	target.Path = tmp
+	err = gitCommands(ctx, "", [][]string{cloneCommand(target)}, stdout, stderr)
+	if err == nil {
+		if err = os.Rename(tmp, folder.Path); err != nil {
+			err = fmt.Errorf("failed to move clone to %s: %v", folder.Path, err)
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// needsClone reports whether repository of folder is missing and is cloned by update
+func needsClone(folder config.Folder) bool {
+	return folder.URL != "" && !IsRepo(RepoPath(folder))
+}
+
+// pullPath runs pre pull hooks, updates folder and runs post pull hooks, atomic folders are deployed
+// into new release. Output of git commands and hooks is collected into result
+func (p *simplePuller) pullPath(ctx context.Context, folder config.Folder, job puller.Job) puller.FolderResult {
+	if folder.Atomic {
+		return p.deployRelease(ctx, folder, job)
+	}
+
+	path := folder.Path
+	start := time.Now()
+	res := puller.FolderResult{Path: path}
+
+	// folder to be cloned does not exist yet, there is nothing to prepare
+	cloning := needsClone(folder) && job.Rollback == ""
+	if !cloning {
+		res.OldHead = head(ctx, path)
+		if err := runHooks(ctx, "pre_pull", folder.PrePull, path, folder, job, &res); err != nil {
+			res.Err = err
+			res.NewHead = res.OldHead
+			res.Duration = time.Since(start)
+			return res
+		}
+	}
+
+	if job.Rollback != "" {
+		p.reset(ctx, folder, job.Rollback, &res)
+	} else {
+		p.update(ctx, folder, updateCommit(job), &res)
+	}
+	if res.NewHead != "" && res.NewHead != res.OldHead {
+		now := time.Now()
+		recordDeploy(folder, Deploy{Time: now, Commit: res.OldHead},
+			Deploy{Time: now, Commit: res.NewHead, Job: job.ID, Rollback: job.Rollback != ""})
+	}
+
+	if res.Err == nil && res.OldHead != "" {
+		changed, err := changedFiles(ctx, path, res.OldHead, res.NewHead)
+		if err != nil {
+			// changes are unknown, all post pull hooks run
+			fmt.Printf("[%s] Changed files are unknown : %v\n", path, err)
+		}
+		res.Changed = changed
+	}
+	if res.Err == nil {
+		fmt.Printf("[%s] Git pull done in %.3f\n", path, time.Since(start).Seconds())
+		res.Err = runHooks(ctx, "post_pull", folder.PostPull, path, folder, job, &res)
+	}
+
+	res.Duration = time.Since(start)
+	return res
+}
+
+// update clones or updates folder, failed attempts are retried by retry policy of folder
+func (p *simplePuller) update(ctx context.Context, folder config.Folder, commit string, res *puller.FolderResult) {
+	res.Err = p.retry(ctx, folder, res, func(ctx context.Context) error {
+		return p.updateOnce(ctx, folder, commit, res)
+	})
+	res.NewHead = head(ctx, folder.Path)
+}
+
+// updateOnce clones folder if it is missing and brings it to the commit
+func (p *simplePuller) updateOnce(ctx context.Context, folder config.Folder, commit string, res *puller.FolderResult) error {
+	path := folder.Path
+	var stdout, stderr bytes.Buffer
+
+	var err error
+	if needsClone(folder) {
+		fmt.Printf("[%s] Cloning %s\n", path, folder.URL)
+		err = p.clone(ctx, folder, &stdout, &stderr)
+	}
+
+	var current string
+	if err == nil && folder.Branch == "" && folder.Strategy == config.StrategyReset {
+		current, err = currentBranch(ctx, path)
+	}
+
+	var cmds [][]string
+	if err == nil {
+		cmds, err = updateCommands(folder, commit, current)
+	}
+	if err == nil {
+		err = gitCommands(ctx, path, cmds, &stdout, &stderr)
+	}
+	if err != nil {
+		fmt.Printf("PullPath %s: %v\n%s", path, err, stderr.String())
+	}
+
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	return err
+}
+
+// Pull updates job folders and returns when all of them are pulled or ctx is done
+func (p *simplePuller) Pull(ctx context.Context, job puller.Job) (*puller.Result, error) {
+	res := &puller.Result{JobID: job.ID}
+	if job.Folders == nil {
+		fmt.Printf("Pull: empty path\n")
+		return res, nil
+	}
+
+	// folders merged into pending pulls of other jobs are not waited for,
+	// the pull reports every job it served
+	var pulls []*pendingPull
+	for _, folder := range job.Folders {
+		if pending := p.schedule(ctx, folder, job); pending != nil {
+			pulls = append(pulls, pending)
+		} else {
+			res.Folders = append(res.Folders, puller.FolderResult{Path: folder.Path, Coalesced: true})
+		}
+	}
+
+	for _, pending := range pulls {
+		select {
+		case <-pending.done:
+			res.Folders = append(res.Folders, pending.result)
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+	return res, res.Err()
+}
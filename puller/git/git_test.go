@@ -1,8 +1,14 @@
 package git
 
 import (
+	"context"
+	"errors"
+	"gitwh/config"
+	"gitwh/puller"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,7 +16,7 @@ import (
 
 func TestNew(t *testing.T) {
 	timeout := 15
-	puller := New(timeout)
+	puller := New(timeout, 0, 0)
 	
 	if puller == nil {
 		t.Error("Expected puller to be created")
@@ -32,10 +38,14 @@ func TestNew(t *testing.T) {
 	if sp.lock == nil {
 		t.Error("Expected lock to be initialized")
 	}
+	
+	if cap(sp.workers) != defaultWorkers {
+		t.Errorf("Expected %d workers by default, got %d", defaultWorkers, cap(sp.workers))
+	}
 }
 
 func TestGetMutex(t *testing.T) {
-	puller := New(10).(*simplePuller)
+	puller := New(10, 0, 2).(*simplePuller)
 	
 	path1 := "/path/to/repo1"
 	path2 := "/path/to/repo2"
@@ -58,7 +68,7 @@ func TestGetMutex(t *testing.T) {
 }
 
 func TestGetMutexConcurrency(t *testing.T) {
-	puller := New(10).(*simplePuller)
+	puller := New(10, 0, 2).(*simplePuller)
 	path := "/test/path"
 	
 	var wg sync.WaitGroup
@@ -86,52 +96,73 @@ func TestGetMutexConcurrency(t *testing.T) {
 	}
 }
 
+func folders(paths ...string) []config.Folder {
+	res := make([]config.Folder, 0, len(paths))
+	for _, path := range paths {
+		res = append(res, config.Folder{Path: path})
+	}
+	return res
+}
+
 func TestPullNilPaths(t *testing.T) {
-	puller := New(10)
+	p := New(10, 0, 2)
 	
-	err := puller.Pull(nil)
+	res, err := p.Pull(context.Background(), puller.Job{})
 	if err != nil {
 		t.Errorf("Expected no error for nil paths, got %v", err)
 	}
+	
+	if res == nil || len(res.Folders) != 0 {
+		t.Errorf("Expected empty result for nil paths, got %+v", res)
+	}
 }
 
 func TestPullEmptyPaths(t *testing.T) {
-	puller := New(10)
+	p := New(10, 0, 2)
 	
-	err := puller.Pull([]string{})
+	_, err := p.Pull(context.Background(), puller.Job{Folders: []config.Folder{}})
 	if err != nil {
 		t.Errorf("Expected no error for empty paths, got %v", err)
 	}
 }
 
 func TestPullValidPath(t *testing.T) {
-	tmpDir := t.TempDir()
-	
-	gitDir := filepath.Join(tmpDir, ".git")
-	err := os.MkdirAll(gitDir, 0755)
+	origin, clone := newOrigin(t)
+	old := runGit(t, clone, "rev-parse", "HEAD")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "pushed")
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	p := New(10, 0, 2)
+
+	res, err := p.Pull(context.Background(), puller.Job{ID: "job", Folders: folders(clone)})
 	if err != nil {
-		t.Fatalf("Failed to create .git directory: %v", err)
+		t.Fatalf("Expected no error for valid pull, got %v", err)
 	}
-	
-	puller := New(1)
-	
-	err = puller.Pull([]string{tmpDir})
-	if err != nil {
-		t.Errorf("Expected no error for valid pull, got %v", err)
+
+	if res.JobID != "job" || len(res.Folders) != 1 {
+		t.Fatalf("Expected result of one folder, got %+v", res)
+	}
+
+	f := res.Folders[0]
+	if f.Path != clone || f.OldHead != old || f.NewHead != pushed || f.Err != nil {
+		t.Errorf("Expected %s updated from %s to %s, got %+v", clone, old, pushed, f)
+	}
+	if f.Duration <= 0 {
+		t.Errorf("Expected duration to be measured, got %v", f.Duration)
 	}
-	
-	time.Sleep(100 * time.Millisecond)
 }
 
 func TestPullInvalidPath(t *testing.T) {
-	puller := New(1)
+	p := New(1, 0, 2)
 	
-	err := puller.Pull([]string{"/non/existent/path"})
-	if err != nil {
-		t.Errorf("Expected no error (async operation), got %v", err)
+	res, err := p.Pull(context.Background(), puller.Job{Folders: folders("/non/existent/path")})
+	if err == nil {
+		t.Error("Expected error for non existent path")
 	}
 	
-	time.Sleep(100 * time.Millisecond)
+	if len(res.Folders) != 1 || res.Folders[0].Err == nil {
+		t.Errorf("Expected failed folder result, got %+v", res)
+	}
 }
 
 func TestPullMultiplePaths(t *testing.T) {
@@ -146,44 +177,47 @@ func TestPullMultiplePaths(t *testing.T) {
 		}
 	}
 	
-	puller := New(1)
+	p := New(1, 0, 2)
 	
-	err := puller.Pull([]string{tmpDir1, tmpDir2})
-	if err != nil {
-		t.Errorf("Expected no error for multiple paths, got %v", err)
+	res, err := p.Pull(context.Background(), puller.Job{Folders: folders(tmpDir1, tmpDir2)})
+	if err == nil {
+		t.Error("Expected error for broken repositories")
+	}
+	
+	if len(res.Folders) != 2 || res.Folders[0].Path != tmpDir1 || res.Folders[1].Path != tmpDir2 {
+		t.Fatalf("Expected results of both folders, got %+v", res)
 	}
 	
-	time.Sleep(200 * time.Millisecond)
+	for _, f := range res.Folders {
+		if f.Err == nil || f.Stderr == "" {
+			t.Errorf("Expected error with git output for %s, got %+v", f.Path, f)
+		}
+	}
 }
 
-func TestPullTimeout(t *testing.T) {
-	puller := New(1)
+func TestPullCanceled(t *testing.T) {
+	p := New(1, 0, 2)
 	
-	err := puller.Pull([]string{"/non/existent/path"})
-	if err != nil {
-		t.Errorf("Expected no error (async operation), got %v", err)
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 	
-	time.Sleep(2 * time.Second)
+	_, err := p.Pull(ctx, puller.Job{Folders: folders(t.TempDir()), Debounce: time.Second})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled error, got %v", err)
+	}
 }
 
 func TestMutexedPullConcurrency(t *testing.T) {
-	tmpDir := t.TempDir()
+	_, clone := newOrigin(t)
 	
-	gitDir := filepath.Join(tmpDir, ".git")
-	err := os.MkdirAll(gitDir, 0755)
-	if err != nil {
-		t.Fatalf("Failed to create .git directory: %v", err)
-	}
-	
-	puller := New(1)
+	p := New(10, 0, 2)
 	
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := puller.Pull([]string{tmpDir})
+			_, err := p.Pull(context.Background(), puller.Job{Folders: folders(clone)})
 			if err != nil {
 				t.Errorf("Expected no error, got %v", err)
 			}
@@ -191,11 +225,423 @@ func TestMutexedPullConcurrency(t *testing.T) {
 	}
 	
 	wg.Wait()
-	time.Sleep(500 * time.Millisecond)
 }
 
 func TestDefaultGitTimeout(t *testing.T) {
 	if defaultGitTimeout != 10 {
 		t.Errorf("Expected defaultGitTimeout to be 10, got %d", defaultGitTimeout)
 	}
-}
\ No newline at end of file
+}
+// runGit runs git command in dir failing the test on error
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newOrigin creates repository with one commit on main branch and its clone
+func newOrigin(t *testing.T) (origin string, clone string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	origin = t.TempDir()
+	runGit(t, origin, "init", "-q", "-b", "main")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "initial")
+
+	clone = filepath.Join(t.TempDir(), "clone")
+	runGit(t, origin, "clone", "-q", origin, clone)
+	return origin, clone
+}
+
+func TestUpdateCommands(t *testing.T) {
+	reset := config.Update{Strategy: config.StrategyReset}
+	clean := config.Update{Strategy: config.StrategyReset, Clean: true, CleanExclude: []string{".env", "storage/"}}
+
+	tests := []struct {
+		folder   config.Folder
+		commit   string
+		expected []string
+	}{
+		{config.Folder{Path: "/repo"}, "abc1234", []string{"pull"}},
+		{config.Folder{Path: "/repo", Remote: "upstream"}, "abc1234", []string{"pull upstream"}},
+		{config.Folder{Path: "/repo", Branch: "main"}, "abc1234", []string{"fetch origin main", "checkout main", "merge --ff-only --end-of-options abc1234"}},
+		{config.Folder{Path: "/repo", Branch: "main", Remote: "upstream"}, "", []string{"fetch upstream main", "checkout main", "merge --ff-only --end-of-options FETCH_HEAD"}},
+		{config.Folder{Path: "/repo", Update: config.Update{Strategy: config.StrategyFFOnly}}, "abc1234", []string{"pull --ff-only"}},
+		{config.Folder{Path: "/repo", Update: config.Update{Strategy: config.StrategyRebase}}, "abc1234", []string{"pull --rebase"}},
+		{config.Folder{Path: "/repo", Branch: "main", Update: config.Update{Strategy: config.StrategyPull}}, "abc1234", []string{"fetch origin main", "checkout main", "merge --end-of-options abc1234"}},
+		{config.Folder{Path: "/repo", Branch: "main", Update: config.Update{Strategy: config.StrategyRebase}}, "abc1234", []string{"fetch origin main", "checkout main", "rebase --end-of-options abc1234"}},
+		{config.Folder{Path: "/repo", Update: reset}, "abc1234", []string{"fetch origin", "reset --hard origin/main"}},
+		{config.Folder{Path: "/repo", Remote: "upstream", Update: reset}, "", []string{"fetch upstream", "reset --hard upstream/main"}},
+		{config.Folder{Path: "/repo", Branch: "main", Update: reset}, "", []string{"fetch origin main", "checkout -f main", "reset --hard origin/main"}},
+		{config.Folder{Path: "/repo", Branch: "main", Update: clean}, "abc1234", []string{"fetch origin main", "checkout -f main", "reset --hard abc1234", "clean -fdx -e .env -e storage/"}},
+		{config.Folder{Path: "/repo", Update: config.Update{Submodules: true, LFS: true, Clean: true}}, "", []string{"pull", "submodule sync --recursive", "submodule update --init --recursive", "lfs pull", "clean -fdx"}},
+	}
+
+	for _, test := range tests {
+		cmds, err := updateCommands(test.folder, test.commit, "main")
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", test.folder, err)
+			continue
+		}
+
+		var got []string
+		for _, cmd := range cmds {
+			got = append(got, strings.Join(cmd, " "))
+		}
+		if strings.Join(got, "; ") != strings.Join(test.expected, "; ") {
+			t.Errorf("%+v: expected %v, got %v", test.folder, test.expected, got)
+		}
+	}
+
+	if _, err := updateCommands(config.Folder{Path: "/repo", Update: config.Update{Strategy: "merge"}}, "", "main"); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+
+	if _, err := updateCommands(config.Folder{Path: "/repo", Update: reset}, "", ""); err == nil {
+		t.Error("Expected error for reset of detached head")
+	}
+
+	for _, commit := range []string{"--exec=touch /tmp/pwned", "HEAD~1", "abc"} {
+		if _, err := updateCommands(config.Folder{Path: "/repo", Branch: "main"}, commit, "main"); err == nil {
+			t.Errorf("Expected error for invalid commit %q", commit)
+		}
+	}
+}
+
+func TestPullPathExactCommit(t *testing.T) {
+	origin, clone := newOrigin(t)
+
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "pushed")
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "later")
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), config.Folder{Path: clone, Branch: "main"}, puller.Job{CommitId: pushed})
+	if res.Err != nil || res.NewHead != pushed {
+		t.Errorf("Expected pull to %s, got %+v", pushed, res)
+	}
+
+	if head := runGit(t, clone, "rev-parse", "HEAD"); head != pushed {
+		t.Errorf("Expected HEAD %s, got %s", pushed, head)
+	}
+}
+
+func TestPullPathTagOffBranch(t *testing.T) {
+	origin, clone := newOrigin(t)
+
+	// tagged commit is not on main, the folder follows main
+	runGit(t, origin, "checkout", "-q", "-b", "hotfix")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "hotfix")
+	tagged := runGit(t, origin, "rev-parse", "HEAD")
+	runGit(t, origin, "tag", "v1.0.1")
+	runGit(t, origin, "checkout", "-q", "main")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "main")
+	main := runGit(t, origin, "rev-parse", "HEAD")
+
+	p := New(10, 0, 2).(*simplePuller)
+	job := puller.Job{CommitId: tagged, Ref: "refs/tags/v1.0.1"}
+	res := p.pullPath(context.Background(), config.Folder{Path: clone, Branch: "main"}, job)
+	if res.Err != nil || res.NewHead != main {
+		t.Errorf("Expected pull to head of main %s, got %+v", main, res)
+	}
+}
+
+func TestPullPathResetRemote(t *testing.T) {
+	_, clone := newOrigin(t)
+
+	mirror, _ := newOrigin(t)
+	runGit(t, mirror, "commit", "-q", "--allow-empty", "-m", "mirrored")
+	mirrored := runGit(t, mirror, "rev-parse", "HEAD")
+	runGit(t, clone, "remote", "add", "mirror", mirror)
+
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{Path: clone, Remote: "mirror", Update: config.Update{Strategy: config.StrategyReset}}
+	res := p.pullPath(context.Background(), folder, puller.Job{})
+	if res.Err != nil || res.NewHead != mirrored {
+		t.Errorf("Expected reset to %s of mirror, got %+v", mirrored, res)
+	}
+}
+
+// pendingJobs returns number of jobs coalesced into pending pull of path
+func pendingJobs(p *simplePuller, path string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if pending, ok := p.pending[path]; ok {
+		return len(pending.jobs)
+	}
+	return 0
+}
+
+func TestPullCoalesce(t *testing.T) {
+	origin, clone := newOrigin(t)
+
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{Path: clone, Branch: "main"}
+
+	var wg sync.WaitGroup
+	var last string
+	var first *puller.Result
+	for i := 0; i < 5; i++ {
+		runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "push")
+		last = runGit(t, origin, "rev-parse", "HEAD")
+		job := puller.Job{ID: string(rune('a' + i)), Folders: []config.Folder{folder}, CommitId: last, Debounce: 500 * time.Millisecond}
+
+		if i == 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := p.Pull(context.Background(), job)
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				first = res
+			}()
+			for pendingJobs(p, clone) != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			continue
+		}
+
+		// coalesced jobs return without waiting for the pull
+		res, err := p.Pull(context.Background(), job)
+		if err != nil || !res.Folders[0].Coalesced {
+			t.Errorf("Expected job %s to be coalesced, got %+v %v", job.ID, res, err)
+		}
+		if n := pendingJobs(p, clone); n != i+1 {
+			t.Errorf("Expected %d pending jobs, got %d", i+1, n)
+		}
+	}
+
+	p.lock.Lock()
+	if pending := p.pending[clone]; pending.job.CommitId != last {
+		t.Errorf("Expected pending pull of %s, got %s", last, pending.job.CommitId)
+	}
+	p.lock.Unlock()
+
+	wg.Wait()
+
+	// the pull started by the first job reports all of them
+	if f := first.Folders[0]; f.Coalesced || strings.Join(f.Jobs, ",") != "a,b,c,d,e" {
+		t.Errorf("Expected pull for jobs a,b,c,d,e, got coalesced %v jobs %v", f.Coalesced, f.Jobs)
+	}
+
+	if n := pendingJobs(p, clone); n != 0 {
+		t.Errorf("Expected no pending jobs after pull, got %d", n)
+	}
+	if head := runGit(t, clone, "rev-parse", "HEAD"); head != last {
+		t.Errorf("Expected HEAD %s, got %s", last, head)
+	}
+}
+
+func TestAcquireWorker(t *testing.T) {
+	p := New(10, 0, 2).(*simplePuller)
+	job := puller.Job{Repo: "app", Workers: 1}
+
+	release, err := p.acquireWorker(context.Background(), job)
+	if err != nil {
+		t.Fatalf("Expected worker, got %v", err)
+	}
+
+	// repository limit is reached, other repositories still get workers
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquireWorker(ctx, job); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected repository limit to block, got %v", err)
+	}
+
+	releaseOther, err := p.acquireWorker(context.Background(), puller.Job{Repo: "other"})
+	if err != nil {
+		t.Fatalf("Expected worker for other repository, got %v", err)
+	}
+
+	// global limit is reached
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquireWorker(ctx, puller.Job{Repo: "third"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected global limit to block, got %v", err)
+	}
+
+	release()
+	releaseOther()
+	if len(p.workers) != 0 || len(p.repoWorkers["app"]) != 0 {
+		t.Errorf("Expected all workers to be released, got %d global and %d of app", len(p.workers), len(p.repoWorkers["app"]))
+	}
+}
+
+func TestPullPathResetStrategy(t *testing.T) {
+	origin, clone := newOrigin(t)
+
+	// diverged history: clone has local commit and changes, origin is force-pushed
+	runGit(t, clone, "commit", "-q", "--allow-empty", "-m", "local")
+	runGit(t, origin, "commit", "-q", "--amend", "--allow-empty", "-m", "rewritten")
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	for file, content := range map[string]string{"junk.txt": "junk", ".env": "SECRET=1"} {
+		if err := os.WriteFile(filepath.Join(clone, file), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{
+		Path:   clone,
+		Branch: "main",
+		Update: config.Update{Strategy: config.StrategyReset, Clean: true, CleanExclude: []string{".env"}},
+	}
+
+	res := p.pullPath(context.Background(), folder, puller.Job{CommitId: pushed})
+	if res.Err != nil {
+		t.Fatalf("Expected reset to succeed, got %v\n%s", res.Err, res.Stderr)
+	}
+
+	if head := runGit(t, clone, "rev-parse", "HEAD"); head != pushed {
+		t.Errorf("Expected HEAD %s, got %s", pushed, head)
+	}
+	if _, err := os.Stat(filepath.Join(clone, "junk.txt")); !os.IsNotExist(err) {
+		t.Error("Expected untracked file to be cleaned")
+	}
+	if _, err := os.Stat(filepath.Join(clone, ".env")); err != nil {
+		t.Errorf("Expected excluded file to be kept, got %v", err)
+	}
+}
+
+func TestCloneCommand(t *testing.T) {
+	folder := config.Folder{
+		Path:   "/srv/app",
+		Branch: "main",
+		Remote: "upstream",
+		Clone:  config.Clone{URL: "git@example.com:acme/app.git", Depth: 1, SingleBranch: true},
+	}
+
+	expected := "clone --depth 1 --branch main --single-branch --origin upstream -- git@example.com:acme/app.git /srv/app"
+	if cmd := strings.Join(cloneCommand(folder), " "); cmd != expected {
+		t.Errorf("Expected %s, got %s", expected, cmd)
+	}
+
+	folder = config.Folder{Path: "/srv/app", Clone: config.Clone{URL: "https://example.com/app.git"}}
+	if cmd := strings.Join(cloneCommand(folder), " "); cmd != "clone -- https://example.com/app.git /srv/app" {
+		t.Errorf("Expected plain clone, got %s", cmd)
+	}
+}
+
+func TestPullClonesMissingFolder(t *testing.T) {
+	origin, _ := newOrigin(t)
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	path := filepath.Join(t.TempDir(), "deploy", "app")
+	if IsRepo(path) {
+		t.Fatal("Expected missing folder not to be a repository")
+	}
+
+	p := New(10, 0, 2)
+	folder := config.Folder{
+		Path:   path,
+		Branch: "main",
+		Clone:  config.Clone{URL: "file://" + origin, Depth: 1, SingleBranch: true},
+	}
+
+	res, err := p.Pull(context.Background(), puller.Job{Folders: []config.Folder{folder}, CommitId: pushed})
+	if err != nil {
+		t.Fatalf("Expected clone to succeed, got %v\n%s", err, res.Folders[0].Stderr)
+	}
+
+	if !IsRepo(path) {
+		t.Fatal("Expected folder to be cloned")
+	}
+	if f := res.Folders[0]; f.OldHead != "" || f.NewHead != pushed {
+		t.Errorf("Expected folder cloned at %s, got %+v", pushed, f)
+	}
+	if count := runGit(t, path, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("Expected shallow clone of 1 commit, got %s", count)
+	}
+}
+
+func TestPullFailedCloneLeavesNoRepository(t *testing.T) {
+	origin, _ := newOrigin(t)
+
+	parent := filepath.Join(t.TempDir(), "deploy")
+	path := filepath.Join(parent, "app")
+	folder := config.Folder{
+		Path:   path,
+		Branch: "missing",
+		Clone:  config.Clone{URL: "file://" + origin},
+	}
+
+	p := New(10, 0, 2)
+	if _, err := p.Pull(context.Background(), puller.Job{Folders: []config.Folder{folder}}); err == nil {
+		t.Fatal("Expected clone of missing branch to fail")
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", parent, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected failed clone to be removed, found %s", entries[0].Name())
+	}
+}
+
+func TestPullPathSubmodules(t *testing.T) {
+	// file transport of submodules is disabled by default
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	origin, clone := newOrigin(t)
+
+	sub := t.TempDir()
+	runGit(t, sub, "init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(sub, "asset.txt"), []byte("v1"), 0644); err != nil {
+		t.Fatalf("Failed to write asset: %v", err)
+	}
+	runGit(t, sub, "add", "asset.txt")
+	runGit(t, sub, "commit", "-q", "-m", "asset")
+
+	runGit(t, origin, "submodule", "add", "-q", sub, "assets")
+	runGit(t, origin, "commit", "-q", "-m", "add assets")
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{Path: clone, Branch: "main", Update: config.Update{Submodules: true}}
+
+	res := p.pullPath(context.Background(), folder, puller.Job{CommitId: pushed})
+	if res.Err != nil {
+		t.Fatalf("Expected pull with submodules to succeed, got %v\n%s", res.Err, res.Stderr)
+	}
+
+	data, err := os.ReadFile(filepath.Join(clone, "assets", "asset.txt"))
+	if err != nil || string(data) != "v1" {
+		t.Errorf("Expected submodule to be checked out, got %q, %v", data, err)
+	}
+}
+
+func TestPullPathLFSFailure(t *testing.T) {
+	_, clone := newOrigin(t)
+	if err := exec.Command("git", "lfs", "version").Run(); err == nil {
+		t.Skip("git lfs is installed")
+	}
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), config.Folder{Path: clone, Update: config.Update{LFS: true}}, puller.Job{})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "git lfs pull") {
+		t.Errorf("Expected lfs pull failure in result, got %v", res.Err)
+	}
+	if res.Stderr == "" {
+		t.Error("Expected lfs pull output in result")
+	}
+}
@@ -0,0 +1,137 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gitwh/config"
+	"gitwh/puller"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyFile keeps deploys of folder, in git dir of regular folder and in root of atomic one
+const historyFile = "gitwh-history"
+
+// Deploy is a record of folder history
+type Deploy struct {
+	Time   time.Time `json:"time"`
+	Commit string    `json:"commit"`
+	// Release is set for atomic folders
+	Release  string `json:"release,omitempty"`
+	Job      string `json:"job,omitempty"`
+	Rollback bool   `json:"rollback,omitempty"`
+}
+
+func historyPath(folder config.Folder) string {
+	if folder.Atomic {
+		return filepath.Join(folder.Path, historyFile)
+	}
+	return filepath.Join(folder.Path, ".git", historyFile)
+}
+
+// History returns deploys of folder from the oldest to the newest
+func History(folder config.Folder) ([]Deploy, error) {
+	f, err := os.Open(historyPath(folder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %v", err)
+	}
+	defer f.Close()
+
+	var history []Deploy
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d Deploy
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			continue
+		}
+		history = append(history, d)
+	}
+	return history, scanner.Err()
+}
+
+// recordDeploy appends deploy to folder history. Empty history is started by the previous state of folder
+func recordDeploy(folder config.Folder, previous, d Deploy) {
+	history, err := History(folder)
+	if err != nil {
+		fmt.Printf("[%s] %v\n", folder.Path, err)
+	}
+
+	var records []Deploy
+	if len(history) == 0 && previous.Commit != "" {
+		records = append(records, previous)
+	}
+	records = append(records, d)
+
+	f, err := os.OpenFile(historyPath(folder), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("[%s] Failed to record deploy : %v\n", folder.Path, err)
+		return
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			fmt.Printf("[%s] Failed to record deploy : %v\n", folder.Path, err)
+			return
+		}
+	}
+}
+
+// rollbackTarget returns deploy to roll back to. Previous target is the last deploy of commit
+// other than the current one, other targets are release names of atomic folder or commits.
+// Commit targets are verified and resolved in repository of folder
+func rollbackTarget(ctx context.Context, folder config.Folder, target string) (Deploy, error) {
+	if strings.HasPrefix(target, "-") {
+		return Deploy{}, fmt.Errorf("invalid rollback target %s", target)
+	}
+
+	if target != puller.RollbackPrevious {
+		if folder.Atomic && isRelease(folder.Path, target) {
+			return Deploy{Release: target}, nil
+		}
+
+		var sha bytes.Buffer
+		cmds := [][]string{{"rev-parse", "--verify", "--end-of-options", target + "^{commit}"}}
+		if err := gitCommands(ctx, RepoPath(folder), cmds, &sha, io.Discard); err != nil {
+			return Deploy{}, fmt.Errorf("unknown commit %s", target)
+		}
+		return Deploy{Commit: strings.TrimSpace(sha.String())}, nil
+	}
+
+	history, err := History(folder)
+	if err != nil {
+		return Deploy{}, err
+	}
+	if len(history) == 0 {
+		return Deploy{}, fmt.Errorf("no deploys recorded")
+	}
+
+	current := history[len(history)-1].Commit
+	for i := len(history) - 2; i >= 0; i-- {
+		if history[i].Commit != current {
+			return history[i], nil
+		}
+	}
+	return Deploy{}, fmt.Errorf("no deploy before %s recorded", current)
+}
+
+// isRelease reports whether name is an existing release of atomic folder
+func isRelease(path, name string) bool {
+	releases, _ := Releases(path)
+	for _, release := range releases {
+		if release == name {
+			return true
+		}
+	}
+	return false
+}
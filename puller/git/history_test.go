@@ -0,0 +1,148 @@
+package git
+
+import (
+	"context"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollbackFolder(t *testing.T) {
+	origin, clone := newOrigin(t)
+	initial := runGit(t, clone, "rev-parse", "HEAD")
+
+	marker := filepath.Join(t.TempDir(), "hooks")
+	folder := config.Folder{Path: clone, Branch: "main", Hooks: config.Hooks{
+		PostPull: []config.Hook{{Command: "git rev-parse HEAD >> " + marker}},
+	}}
+	p := New(10, 0, 2).(*simplePuller)
+
+	first := commitFile(t, origin, "app.txt", "v1")
+	if res := p.pullPath(context.Background(), folder, puller.Job{ID: "j1", CommitId: first}); res.Err != nil {
+		t.Fatalf("Expected pull to succeed, got %v", res.Err)
+	}
+	second := commitFile(t, origin, "app.txt", "v2")
+	if res := p.pullPath(context.Background(), folder, puller.Job{ID: "j2", CommitId: second}); res.Err != nil {
+		t.Fatalf("Expected pull to succeed, got %v", res.Err)
+	}
+
+	history, err := History(folder)
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if len(history) != 3 || history[0].Commit != initial || history[1].Commit != first || history[2].Commit != second {
+		t.Fatalf("Expected history of initial, first and second commits, got %+v", history)
+	}
+
+	res := p.pullPath(context.Background(), folder, puller.Job{ID: "r1", Rollback: puller.RollbackPrevious})
+	if res.Err != nil || res.OldHead != second || res.NewHead != first {
+		t.Fatalf("Expected rollback from %s to %s, got %+v", second, first, res)
+	}
+	if data, _ := os.ReadFile(filepath.Join(clone, "app.txt")); string(data) != "v1" {
+		t.Errorf("Expected v1 to be checked out, got %q", data)
+	}
+
+	data, _ := os.ReadFile(marker)
+	if heads := strings.Fields(string(data)); len(heads) != 3 || heads[2] != first {
+		t.Errorf("Expected post_pull hook to run after rollback, got %v", heads)
+	}
+
+	history, _ = History(folder)
+	if last := history[len(history)-1]; last.Commit != first || !last.Rollback || last.Job != "r1" {
+		t.Errorf("Expected rollback to be recorded, got %+v", last)
+	}
+
+	res = p.pullPath(context.Background(), folder, puller.Job{Rollback: initial[:8]})
+	if res.Err != nil || res.NewHead != initial {
+		t.Errorf("Expected rollback to %s, got %+v", initial, res)
+	}
+
+	// next push is pulled after rollback
+	if res := p.pullPath(context.Background(), folder, puller.Job{CommitId: second}); res.Err != nil || res.NewHead != second {
+		t.Errorf("Expected pull of %s after rollback, got %+v", second, res)
+	}
+}
+
+func TestRollbackWithoutHistory(t *testing.T) {
+	_, clone := newOrigin(t)
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), config.Folder{Path: clone}, puller.Job{Rollback: puller.RollbackPrevious})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "no deploys recorded") {
+		t.Errorf("Expected rollback to fail without history, got %v", res.Err)
+	}
+}
+
+func TestRollbackInvalidTarget(t *testing.T) {
+	origin, _ := newOrigin(t)
+	root := filepath.Join(t.TempDir(), "site")
+
+	folder := config.Folder{Path: root, Branch: "main", Atomic: true, Clone: config.Clone{URL: origin}}
+	p := New(10, 0, 2).(*simplePuller)
+	if res := p.pullPath(context.Background(), folder, puller.Job{}); res.Err != nil {
+		t.Fatalf("Expected release to succeed, got %v", res.Err)
+	}
+	current := CurrentRelease(root)
+
+	for _, target := range []string{"../repo", "--output=/tmp/x", "missing"} {
+		res := p.pullPath(context.Background(), folder, puller.Job{Rollback: target})
+		if res.Err == nil {
+			t.Errorf("%s: expected rollback to fail", target)
+		}
+		if CurrentRelease(root) != current {
+			t.Errorf("%s: expected current release to stay %s, got %s", target, current, CurrentRelease(root))
+		}
+	}
+}
+
+func TestRollbackRelease(t *testing.T) {
+	origin, _ := newOrigin(t)
+	root := filepath.Join(t.TempDir(), "site")
+
+	folder := config.Folder{
+		Path:   root,
+		Branch: "main",
+		Atomic: true,
+		Clone:  config.Clone{URL: origin},
+		Hooks:  config.Hooks{PostPull: []config.Hook{{Command: "touch hooked"}}},
+	}
+	p := New(10, 0, 2).(*simplePuller)
+
+	first := commitFile(t, origin, "index.html", "v1")
+	if res := p.pullPath(context.Background(), folder, puller.Job{CommitId: first}); res.Err != nil {
+		t.Fatalf("Expected release to succeed, got %v", res.Err)
+	}
+	firstRelease := CurrentRelease(root)
+	if err := os.Remove(filepath.Join(root, currentLink, "hooked")); err != nil {
+		t.Fatalf("Expected post_pull hook to run: %v", err)
+	}
+
+	second := commitFile(t, origin, "index.html", "v2")
+	if res := p.pullPath(context.Background(), folder, puller.Job{CommitId: second}); res.Err != nil {
+		t.Fatalf("Expected release to succeed, got %v", res.Err)
+	}
+	secondRelease := CurrentRelease(root)
+
+	res := p.pullPath(context.Background(), folder, puller.Job{Rollback: puller.RollbackPrevious})
+	if res.Err != nil || res.NewHead != first {
+		t.Fatalf("Expected rollback to %s, got %+v", first, res)
+	}
+	if CurrentRelease(root) != firstRelease {
+		t.Errorf("Expected existing release %s to be current, got %s", firstRelease, CurrentRelease(root))
+	}
+	if _, err := os.Stat(filepath.Join(root, currentLink, "hooked")); err != nil {
+		t.Errorf("Expected post_pull hook to run in rolled back release: %v", err)
+	}
+
+	res = p.pullPath(context.Background(), folder, puller.Job{Rollback: secondRelease})
+	if res.Err != nil || CurrentRelease(root) != secondRelease {
+		t.Errorf("Expected rollback to release %s, got %s, %v", secondRelease, CurrentRelease(root), res.Err)
+	}
+
+	if releases, _ := Releases(root); len(releases) != 2 {
+		t.Errorf("Expected rollbacks not to create releases, got %v", releases)
+	}
+}
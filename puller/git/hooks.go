@@ -0,0 +1,134 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultHookTimeout = 60
+
+// hookEnv returns environment of hooks describing the job, dir is where hook runs
+func hookEnv(dir string, folder config.Folder, job puller.Job) []string {
+	return append(os.Environ(),
+		"GITWH_DIR="+dir,
+		"GITWH_JOB="+job.ID,
+		"GITWH_REPO="+job.Repo,
+		"GITWH_FOLDER="+folder.Path,
+		"GITWH_BRANCH="+folder.Branch,
+		"GITWH_COMMIT="+job.CommitId,
+		"GITWH_REF="+job.Ref,
+		"GITWH_PUSHER="+job.Pusher,
+	)
+}
+
+// runHook runs hook command by shell in dir with its own timeout
+func runHook(ctx context.Context, hook config.Hook, dir string, folder config.Folder, job puller.Job) puller.HookResult {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Dir = dir
+	cmd.Env = hookEnv(dir, folder, job)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// background children keeping output open must not block the hook after timeout
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	res := puller.HookResult{Command: hook.Command}
+	if err := cmd.Run(); err != nil {
+		res.Err = err
+		if ctx.Err() == context.DeadlineExceeded {
+			res.Err = fmt.Errorf("timed out after %ds", timeout)
+		}
+	}
+	res.Duration = time.Since(start)
+	res.Output = out.String()
+	return res
+}
+
+// matchPath reports whether slash separated name matches glob pattern.
+// "**" segment matches any number of segments, other segments are matched by path.Match
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// hookChanged reports whether hook should run for changed files.
+// Hooks without paths always run, nil changed means files are unknown (e.g. fresh clone)
+func hookChanged(hook config.Hook, changed []string) bool {
+	if len(hook.Paths) == 0 || changed == nil {
+		return true
+	}
+
+	for _, name := range changed {
+		for _, pattern := range hook.Paths {
+			if matchPath(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runHooks runs hooks one by one and appends their results to the folder result.
+// Hooks with paths are skipped unless matching files are changed.
+// It stops on the first failed hook which does not ignore errors
+func runHooks(ctx context.Context, stage string, hooks []config.Hook, dir string, folder config.Folder, job puller.Job, res *puller.FolderResult) error {
+	for _, hook := range hooks {
+		if !hookChanged(hook, res.Changed) {
+			fmt.Printf("[%s] %s hook %q skipped, no matching files changed\n", folder.Path, stage, hook.Command)
+			res.Hooks = append(res.Hooks, puller.HookResult{Command: hook.Command, Skipped: true})
+			continue
+		}
+
+		hr := runHook(ctx, hook, dir, folder, job)
+		res.Hooks = append(res.Hooks, hr)
+
+		if hr.Err == nil {
+			fmt.Printf("[%s] %s hook %q done in %.3f\n", folder.Path, stage, hook.Command, hr.Duration.Seconds())
+			continue
+		}
+
+		fmt.Printf("[%s] %s hook %q failed : %v\n%s", folder.Path, stage, hook.Command, hr.Err, hr.Output)
+		if !hook.IgnoreErrors {
+			return fmt.Errorf("%s hook %q: %v", stage, hook.Command, hr.Err)
+		}
+	}
+	return nil
+}
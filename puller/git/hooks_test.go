@@ -0,0 +1,180 @@
+package git
+
+import (
+	"context"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHookEnv(t *testing.T) {
+	dir := t.TempDir()
+	folder := config.Folder{Path: dir, Branch: "main"}
+	job := puller.Job{ID: "j1", Repo: "acme/app", CommitId: "abc", Ref: "refs/heads/main", Pusher: "jane"}
+
+	res := runHook(context.Background(), config.Hook{Command: `echo "$GITWH_COMMIT $GITWH_REF $GITWH_PUSHER $GITWH_REPO $GITWH_BRANCH"; pwd`}, dir, folder, job)
+	if res.Err != nil {
+		t.Fatalf("Expected hook to succeed, got %v", res.Err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(res.Output), "\n")
+	if lines[0] != "abc refs/heads/main jane acme/app main" {
+		t.Errorf("Expected push described by environment, got %q", lines[0])
+	}
+	if resolved, _ := filepath.EvalSymlinks(dir); len(lines) < 2 || lines[1] != resolved {
+		t.Errorf("Expected hook to run in %s, got %v", resolved, lines)
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	res := runHook(context.Background(), config.Hook{Command: "sleep 5", Timeout: 1}, t.TempDir(), config.Folder{}, puller.Job{})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got %v", res.Err)
+	}
+}
+
+func TestRunHooksFailure(t *testing.T) {
+	folder := config.Folder{Path: t.TempDir()}
+	hooks := []config.Hook{
+		{Command: "exit 1", IgnoreErrors: true},
+		{Command: "echo second"},
+		{Command: "exit 2"},
+		{Command: "echo never"},
+	}
+
+	var res puller.FolderResult
+	err := runHooks(context.Background(), "post_pull", hooks, folder.Path, folder, puller.Job{}, &res)
+	if err == nil || !strings.Contains(err.Error(), `"exit 2"`) {
+		t.Errorf("Expected failure of exit 2 hook, got %v", err)
+	}
+
+	if len(res.Hooks) != 3 {
+		t.Fatalf("Expected 3 hooks run, got %+v", res.Hooks)
+	}
+	if res.Hooks[0].Err == nil || res.Hooks[1].Err != nil || strings.TrimSpace(res.Hooks[1].Output) != "second" {
+		t.Errorf("Expected ignored failure followed by successful hook, got %+v", res.Hooks)
+	}
+}
+
+func TestPullPathHooks(t *testing.T) {
+	origin, clone := newOrigin(t)
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "pushed")
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.Folder{
+		Path:   clone,
+		Branch: "main",
+		Hooks: config.Hooks{
+			PrePull:  []config.Hook{{Command: "git rev-parse HEAD > " + marker}},
+			PostPull: []config.Hook{{Command: "git rev-parse HEAD >> " + marker}},
+		},
+	}
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), folder, puller.Job{CommitId: pushed})
+	if res.Err != nil {
+		t.Fatalf("Expected pull to succeed, got %v", res.Err)
+	}
+
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("Expected hooks to write marker: %v", err)
+	}
+	heads := strings.Fields(string(data))
+	if len(heads) != 2 || heads[0] != res.OldHead || heads[1] != pushed {
+		t.Errorf("Expected pre hook to see %s and post hook %s, got %v", res.OldHead, pushed, heads)
+	}
+}
+
+func TestPullPathPreHookFailure(t *testing.T) {
+	origin, clone := newOrigin(t)
+	old := runGit(t, clone, "rev-parse", "HEAD")
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "pushed")
+
+	folder := config.Folder{Path: clone, Hooks: config.Hooks{
+		PrePull:  []config.Hook{{Command: "exit 3"}},
+		PostPull: []config.Hook{{Command: "echo post"}},
+	}}
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), folder, puller.Job{})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "pre_pull") {
+		t.Errorf("Expected pre_pull failure, got %v", res.Err)
+	}
+
+	if head := runGit(t, clone, "rev-parse", "HEAD"); head != old {
+		t.Errorf("Expected folder not to be pulled, got HEAD %s", head)
+	}
+	if len(res.Hooks) != 1 {
+		t.Errorf("Expected post_pull hooks to be skipped, got %+v", res.Hooks)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"composer.lock", "composer.lock", true},
+		{"composer.lock", "vendor/composer.lock", false},
+		{"frontend/**", "frontend/src/app.js", true},
+		{"frontend/**", "frontend", true},
+		{"frontend/**", "backend/app.go", false},
+		{"**/*.js", "app.js", true},
+		{"**/*.js", "frontend/src/app.js", true},
+		{"**/*.js", "frontend/src/app.go", false},
+		{"src/**/test/*.go", "src/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/test/a.go", true},
+		{"src/*.go", "src/a/b.go", false},
+		{"package*.json", "package-lock.json", true},
+	}
+
+	for _, test := range tests {
+		if match := matchPath(test.pattern, test.name); match != test.match {
+			t.Errorf("matchPath(%q, %q) = %v, expected %v", test.pattern, test.name, match, test.match)
+		}
+	}
+}
+
+func TestPullPathChangedPaths(t *testing.T) {
+	origin, clone := newOrigin(t)
+
+	if err := os.MkdirAll(filepath.Join(origin, "backend"), 0755); err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(origin, "backend", "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	runGit(t, origin, "add", ".")
+	runGit(t, origin, "commit", "-q", "-m", "backend")
+
+	folder := config.Folder{Path: clone, Hooks: config.Hooks{PostPull: []config.Hook{
+		{Command: "echo frontend", Paths: []string{"frontend/**", "package.json"}},
+		{Command: "echo backend", Paths: []string{"backend/**"}},
+		{Command: "echo always"},
+	}}}
+
+	p := New(10, 0, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), folder, puller.Job{})
+	if res.Err != nil {
+		t.Fatalf("Expected pull to succeed, got %v", res.Err)
+	}
+
+	if len(res.Changed) != 1 || res.Changed[0] != "backend/main.go" {
+		t.Errorf("Expected backend/main.go changed, got %v", res.Changed)
+	}
+	if len(res.Hooks) != 3 || !res.Hooks[0].Skipped || res.Hooks[1].Skipped || res.Hooks[2].Skipped {
+		t.Errorf("Expected only frontend hook to be skipped, got %+v", res.Hooks)
+	}
+
+	// nothing changed, only hooks without paths run
+	res = p.pullPath(context.Background(), folder, puller.Job{})
+	if len(res.Hooks) != 3 || !res.Hooks[1].Skipped || res.Hooks[2].Skipped || strings.TrimSpace(res.Hooks[2].Output) != "always" {
+		t.Errorf("Expected hooks with paths to be skipped, got %+v", res.Hooks)
+	}
+}
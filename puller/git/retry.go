@@ -0,0 +1,126 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitwh/config"
+	"gitwh/puller"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAttempts   = 3
+	defaultBackoff    = 1.0
+	defaultMaxBackoff = 60.0
+)
+
+// defaultRetryOn are failure kinds retried when retry policy does not list them
+var defaultRetryOn = []string{config.FailureTimeout, config.FailureNetwork, config.FailureLock}
+
+// failurePatterns map lower case git output to failure kinds, checked in order
+var failurePatterns = []struct {
+	kind     string
+	patterns []string
+}{
+	{config.FailureLock, []string{".lock': file exists", "index.lock", "another git process seems to be running"}},
+	{config.FailureConflict, []string{"conflict", "not possible to fast-forward", "diverging branches",
+		"would be overwritten", "not something we can merge", "unmerged files"}},
+	{config.FailureNetwork, []string{"could not resolve host", "connection timed out", "connection refused",
+		"connection reset", "operation timed out", "network is unreachable", "early eof", "the remote end hung up",
+		"returned error: 5", "http 5", "ssh: connect to host", "temporary failure", "tls connection"}},
+}
+
+// classify returns failure kind of git commands by their error and output
+func classify(ctx context.Context, err error, output string) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return config.FailureTimeout
+	}
+
+	output = strings.ToLower(output)
+	for _, failure := range failurePatterns {
+		for _, pattern := range failure.patterns {
+			if strings.Contains(output, pattern) {
+				return failure.kind
+			}
+		}
+	}
+	return config.FailureOther
+}
+
+// retryable reports whether failure kind is retried by policy
+func retryable(policy *config.Retry, kind string) bool {
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+
+	for _, k := range retryOn {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns delay before attempt following the failed one, attempts are numbered from 1
+func backoff(policy *config.Retry, attempt int) time.Duration {
+	base := policy.Backoff
+	if base <= 0 {
+		base = defaultBackoff
+	}
+	max := policy.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	delay := math.Min(base*math.Pow(2, float64(attempt-1)), max)
+	if policy.Jitter > 0 {
+		delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay * float64(time.Second))
+}
+
+// retry runs update of folder within git timeout, or clone timeout while folder is to be cloned.
+// Failed attempts are repeated by retry policy of folder.
+// Each attempt is appended to the result, update writes its git output into result
+func (p *simplePuller) retry(ctx context.Context, folder config.Folder, res *puller.FolderResult, update func(ctx context.Context) error) error {
+	attempts := 1
+	if folder.Retry != nil {
+		attempts = folder.Retry.Attempts
+		if attempts <= 0 {
+			attempts = defaultAttempts
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		timeout := p.gitTimeout
+		if needsClone(folder) {
+			timeout = p.cloneTimeout
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		err := update(attemptCtx)
+		kind := classify(attemptCtx, err, res.Stderr)
+		cancel()
+
+		res.Attempts = append(res.Attempts, puller.Attempt{Duration: time.Since(start), Kind: kind, Err: err})
+		if err == nil || attempt >= attempts || !retryable(folder.Retry, kind) {
+			return err
+		}
+
+		delay := backoff(folder.Retry, attempt)
+		fmt.Printf("[%s] Attempt %d of %d failed (%s), retrying in %.1fs : %v\n", folder.Path, attempt, attempts, kind, delay.Seconds(), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		output string
+		kind   string
+	}{
+		{"fatal: unable to access 'https://example.com/app.git/': Could not resolve host: example.com", config.FailureNetwork},
+		{"fatal: unable to access 'https://example.com/app.git/': The requested URL returned error: 502", config.FailureNetwork},
+		{"fatal: Unable to create '/srv/app/.git/index.lock': File exists.", config.FailureLock},
+		{"CONFLICT (content): Merge conflict in app.txt", config.FailureConflict},
+		{"fatal: Not possible to fast-forward, aborting.", config.FailureConflict},
+		{"fatal: Authentication failed", config.FailureOther},
+	}
+
+	for _, test := range tests {
+		if kind := classify(ctx, errors.New("exit status 128"), test.output); kind != test.kind {
+			t.Errorf("classify(%q) = %s, expected %s", test.output, kind, test.kind)
+		}
+	}
+
+	if kind := classify(ctx, nil, "Could not resolve host"); kind != "" {
+		t.Errorf("Expected no failure kind without error, got %s", kind)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancel()
+	<-timeout.Done()
+	if kind := classify(timeout, errors.New("signal: killed"), ""); kind != config.FailureTimeout {
+		t.Errorf("Expected timeout failure, got %s", kind)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &config.Retry{Backoff: 2, MaxBackoff: 10}
+
+	expected := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, delay := range expected {
+		if got := backoff(policy, i+1); got != delay {
+			t.Errorf("Attempt %d: expected delay %v, got %v", i+1, delay, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := backoff(policy, 1); got < time.Second || got > 3*time.Second {
+			t.Fatalf("Expected delay within 50%% of 2s, got %v", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{Path: "/srv/app", Retry: &config.Retry{Attempts: 3, Backoff: 0.01}}
+
+	outputs := []string{"fatal: Could not resolve host: example.com", "error: early EOF", ""}
+	var res puller.FolderResult
+	calls := 0
+	err := p.retry(context.Background(), folder, &res, func(ctx context.Context) error {
+		res.Stderr = outputs[calls]
+		calls++
+		if res.Stderr != "" {
+			return errors.New("exit status 128")
+		}
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Fatalf("Expected success on third attempt, got %v after %d calls", err, calls)
+	}
+	if len(res.Attempts) != 3 || res.Attempts[0].Kind != config.FailureNetwork || res.Attempts[2].Err != nil {
+		t.Errorf("Expected attempts to be recorded, got %+v", res.Attempts)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	p := New(10, 0, 2).(*simplePuller)
+
+	tests := []struct {
+		retry  *config.Retry
+		output string
+	}{
+		{nil, "fatal: Could not resolve host: example.com"},
+		{&config.Retry{Attempts: 3, Backoff: 0.01}, "CONFLICT (content): Merge conflict in app.txt"},
+		{&config.Retry{Attempts: 3, Backoff: 0.01, RetryOn: []string{config.FailureTimeout}}, "error: early EOF"},
+	}
+
+	for _, test := range tests {
+		var res puller.FolderResult
+		calls := 0
+		err := p.retry(context.Background(), config.Folder{Path: "/srv/app", Retry: test.retry}, &res, func(ctx context.Context) error {
+			calls++
+			res.Stderr = test.output
+			return errors.New("exit status 1")
+		})
+
+		if err == nil || calls != 1 || len(res.Attempts) != 1 {
+			t.Errorf("%q: expected single failed attempt, got %v after %d calls", test.output, err, calls)
+		}
+	}
+}
+
+func TestPullPathRetryAttempts(t *testing.T) {
+	_, clone := newOrigin(t)
+	runGit(t, clone, "remote", "set-url", "origin", "/non/existent/origin")
+
+	p := New(10, 0, 2).(*simplePuller)
+	folder := config.Folder{Path: clone, Retry: &config.Retry{Attempts: 2, Backoff: 0.01, RetryOn: []string{config.FailureOther}}}
+
+	res := p.pullPath(context.Background(), folder, puller.Job{})
+	if res.Err == nil {
+		t.Fatal("Expected pull from missing origin to fail")
+	}
+	if len(res.Attempts) != 2 || res.Attempts[1].Kind != config.FailureOther {
+		t.Errorf("Expected 2 failed attempts, got %+v", res.Attempts)
+	}
+}
+
+func TestPullRetriesTimedOutClone(t *testing.T) {
+	origin, _ := newOrigin(t)
+	pushed := runGit(t, origin, "rev-parse", "HEAD")
+
+	// the first fetch of origin stalls until clone is killed by timeout
+	dir := t.TempDir()
+	script := filepath.Join(dir, "stall.sh")
+	stall := "#!/bin/sh\nif [ ! -e " + dir + "/stalled ]; then touch " + dir + "/stalled; exec sleep 5 >/dev/null 2>&1; fi\nexec git upload-pack " + origin + "\n"
+	if err := os.WriteFile(script, []byte(stall), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.ext.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	path := filepath.Join(t.TempDir(), "app")
+	folder := config.Folder{
+		Path:   path,
+		Branch: "main",
+		Clone:  config.Clone{URL: "ext::" + script},
+		Retry:  &config.Retry{Attempts: 2, Backoff: 0.01},
+	}
+
+	p := New(10, 1, 2).(*simplePuller)
+	res := p.pullPath(context.Background(), folder, puller.Job{CommitId: pushed})
+	if res.Err != nil {
+		t.Fatalf("Expected retried clone to succeed, got %v\n%s", res.Err, res.Stderr)
+	}
+	if len(res.Attempts) != 2 || res.Attempts[0].Kind != config.FailureTimeout {
+		t.Errorf("Expected timed out attempt followed by successful one, got %+v", res.Attempts)
+	}
+	if res.NewHead != pushed {
+		t.Errorf("Expected folder cloned at %s, got %s", pushed, res.NewHead)
+	}
+}
@@ -1,6 +1,104 @@
 package puller
 
-// Puller an interface for pull
+import (
+	"context"
+	"fmt"
+	"gitwh/config"
+	"regexp"
+	"time"
+)
+
+// RollbackPrevious is a rollback target meaning the deploy before the current one
+const RollbackPrevious = "previous"
+
+// commitPattern matches abbreviated and full hex object IDs, SHA-1 and SHA-256 ones
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// IsCommitID reports whether id is a hex object ID of commit, anything else must not reach git commands
+func IsCommitID(id string) bool {
+	return commitPattern.MatchString(id)
+}
+
+// Job describes folders to be updated and commit pushed into repository
+type Job struct {
+	ID string
+	// Repo is repository name in config
+	Repo     string
+	Folders  []config.Folder
+	CommitId string
+	Ref      string
+	// Pusher is a name of user who triggered the job
+	Pusher string
+	// Debounce delays the pull, triggers of the same folder within it are coalesced
+	Debounce time.Duration
+	// Workers limits folders of repository pulled at once, zero means only global limit
+	Workers int
+	// Rollback makes folders return to a deployed commit or release instead of update,
+	// RollbackPrevious or commit or release name
+	Rollback string
+}
+
+// HookResult describes run of pre or post pull hook
+type HookResult struct {
+	Command string
+	// Skipped is set when no files matching hook paths changed
+	Skipped  bool
+	Duration time.Duration
+	Output   string
+	Err      error
+}
+
+// Attempt describes an attempt of folder update
+type Attempt struct {
+	Duration time.Duration
+	// Kind is a failure kind of git commands, empty if attempt succeeded
+	Kind string
+	Err  error
+}
+
+// FolderResult describes update of a single folder
+type FolderResult struct {
+	Path     string
+	OldHead  string
+	NewHead  string
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+	// Attempts are attempts of git update, more than one if failed ones are retried
+	Attempts []Attempt
+	// Changed are files changed between old and new HEAD
+	Changed []string
+	Hooks   []HookResult
+	Err     error
+	// Coalesced is set when folder update is merged into pending pull started by another job,
+	// the folder is not pulled yet when Pull returns
+	Coalesced bool
+	// Jobs are IDs of jobs served by the pull, the first is the job which started it
+	Jobs []string
+}
+
+// Result describes updates of job folders
+type Result struct {
+	JobID   string
+	Folders []FolderResult
+}
+
+// Err returns error of failed folders, nil if all of them are updated
+func (r *Result) Err() error {
+	var failed []string
+	for _, f := range r.Folders {
+		if f.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.Path, f.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d folder(s) failed: %v", len(failed), len(r.Folders), failed)
+}
+
+// Puller an interface for pull, Pull returns when job folders are updated
+// or coalesced into pulls of other jobs
 type Puller interface {
-	Pull([]string) error
+	Pull(ctx context.Context, job Job) (*Result, error)
 }
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalFile is a name of journal in state directory
+const journalFile = "jobs.journal"
+
+// Record operations
+const (
+	OpAccept = "accept"
+	OpDone   = "done"
+)
+
+// record is a line of journal
+type record struct {
+	Op   string      `json:"op"`
+	ID   string      `json:"id"`
+	Job  *puller.Job `json:"job,omitempty"`
+	Time time.Time   `json:"time"`
+}
+
+// Journal is an append-only log of accepted and completed jobs
+type Journal struct {
+	lock    *sync.Mutex
+	f       *os.File
+	pending []puller.Job
+}
+
+// Open opens journal in state directory.
+// Journal is compacted on open, only jobs accepted but not completed before are kept.
+// Jobs carry folder URLs which may include credentials, so journal is readable by owner only
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %v", err)
+	}
+
+	path := filepath.Join(dir, journalFile)
+	pending, err := readPending(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := compact(path, pending); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	return &Journal{lock: &sync.Mutex{}, f: f, pending: pending}, nil
+}
+
+// Pending returns jobs which were not completed before journal was opened, in order of acceptance
+func (j *Journal) Pending() []puller.Job {
+	return j.pending
+}
+
+// readPending returns accepted jobs without done record in order of acceptance
+func readPending(path string) ([]puller.Job, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer f.Close()
+
+	var accepted []puller.Job
+	done := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// last line may be truncated by crash
+			fmt.Printf("Journal: skip broken record: %v\n", err)
+			continue
+		}
+
+		switch rec.Op {
+		case OpAccept:
+			if rec.Job != nil {
+				accepted = append(accepted, *rec.Job)
+			}
+		case OpDone:
+			done[rec.ID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %v", err)
+	}
+
+	var pending []puller.Job
+	for _, job := range accepted {
+		if !done[job.ID] {
+			pending = append(pending, job)
+		}
+	}
+	return pending, nil
+}
+
+// compact atomically rewrites journal with accept records of pending jobs
+func compact(path string, pending []puller.Job) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	// temporary file left by previous run keeps its mode, compacted journal replaces an existing one
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+
+	enc := json.NewEncoder(f)
+	for i := range pending {
+		if err := enc.Encode(record{Op: OpAccept, ID: pending[i].ID, Job: &pending[i], Time: time.Now()}); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact journal: %v", err)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to compact journal: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (j *Journal) write(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return j.f.Sync()
+}
+
+// Accept records accepted job
+func (j *Journal) Accept(job puller.Job) error {
+	return j.write(record{Op: OpAccept, ID: job.ID, Job: &job, Time: time.Now()})
+}
+
+// Done records completed job, it is not replayed anymore
+func (j *Journal) Done(id string) error {
+	return j.write(record{Op: OpDone, ID: id, Time: time.Now()})
+}
+
+// Close closes journal file
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"gitwh/config"
+	"gitwh/puller"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func job(id string) puller.Job {
+	return puller.Job{ID: id, Folders: []config.Folder{{Path: "/srv/" + id, Branch: "main"}}, CommitId: "c-" + id}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if len(j.Pending()) != 0 {
+		t.Errorf("Expected no pending jobs in new journal, got %v", j.Pending())
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := j.Accept(job(id)); err != nil {
+			t.Fatalf("Failed to accept job: %v", err)
+		}
+	}
+	if err := j.Done("b"); err != nil {
+		t.Fatalf("Failed to complete job: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Failed to close journal: %v", err)
+	}
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+
+	pending := j.Pending()
+	if len(pending) != 2 || pending[0].ID != "a" || pending[1].ID != "c" {
+		t.Fatalf("Expected pending jobs a and c, got %v", pending)
+	}
+	if pending[1].CommitId != "c-c" || pending[1].Folders[0].Branch != "main" {
+		t.Errorf("Expected job to be restored, got %+v", pending[1])
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	_ = j.Accept(job("a"))
+	_ = j.Done("a")
+	_ = j.Close()
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+
+	info, err := os.Stat(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatalf("Failed to stat journal: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected completed jobs to be compacted, journal size %d", info.Size())
+	}
+}
+
+func TestJournalPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+
+	// journal written by previous versions was readable by everyone
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	_ = j.Close()
+	if err := os.Chmod(filepath.Join(dir, journalFile), 0644); err != nil {
+		t.Fatalf("Failed to chmod journal: %v", err)
+	}
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+
+	for path, mode := range map[string]os.FileMode{dir: 0700, filepath.Join(dir, journalFile): 0600} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("Expected %s mode %v, got %v", path, mode, info.Mode().Perm())
+		}
+	}
+}
+
+func TestJournalTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	_ = j.Accept(job("a"))
+	_ = j.Close()
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal file: %v", err)
+	}
+	_, _ = f.WriteString(`{"op":"done","id":"a"`)
+	_ = f.Close()
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+
+	if pending := j.Pending(); len(pending) != 1 || pending[0].ID != "a" {
+		t.Errorf("Expected job a to be pending, got %v", pending)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gitwh/config"
+	"gitwh/handlers"
+	"gitwh/puller"
+	"gitwh/puller/git"
+)
+
+const rollbackUsage = `Usage: gitwh rollback [-config path] [-folder path] [-to commit|release] [-list] <repo>
+
+Rolls folders of repository back to the previous deploy or to the given commit or release,
+pre_pull and post_pull hooks are run as on pull. Stop the server or use admin API
+(POST /admin/rollback/{repo}) to avoid concurrent pulls.
+`
+
+// rollbackCommand runs rollback subcommand and returns exit code
+func rollbackCommand(args []string) int {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	configPath := fs.String("config", "/etc/gitwh.yaml", "Configuration file path")
+	folder := fs.String("folder", "", "Roll back only this folder")
+	to := fs.String("to", puller.RollbackPrevious, "Commit or release to roll back to")
+	list := fs.Bool("list", false, "List deploy history instead of rollback")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), rollbackUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.FromFile(*configPath)
+	if err != nil {
+		fmt.Printf("Failed to load config: %v\n", err)
+		return 1
+	}
+
+	name := fs.Arg(0)
+	repo, ok := cfg.Repos[name]
+	if !ok {
+		fmt.Printf("Repository %s not found in config\n", name)
+		return 1
+	}
+
+	job, err := handlers.RollbackJob(name, repo, *folder, *to)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	job.ID = "rollback"
+
+	if *list {
+		return listHistory(job.Folders)
+	}
+
+	res, err := git.New(cfg.Timeout, cfg.CloneTimeout, cfg.Workers).Pull(context.Background(), *job)
+	for _, f := range res.Folders {
+		if f.Err != nil {
+			fmt.Printf("%s: rollback failed : %v\n", f.Path, f.Err)
+			continue
+		}
+		fmt.Printf("%s: %s -> %s\n", f.Path, f.OldHead, f.NewHead)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// listHistory prints deploy history of folders
+func listHistory(folders []config.Folder) int {
+	code := 0
+	for _, f := range folders {
+		fmt.Printf("%s:\n", f.Path)
+
+		history, err := git.History(f)
+		if err != nil {
+			fmt.Printf("  %v\n", err)
+			code = 1
+			continue
+		}
+
+		for _, d := range history {
+			line := fmt.Sprintf("  %s %s", d.Time.Format("2006-01-02 15:04:05"), d.Commit)
+			if d.Release != "" {
+				line += " " + d.Release
+			}
+			if d.Rollback {
+				line += " (rollback)"
+			}
+			fmt.Println(line)
+		}
+	}
+	return code
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollbackCommandUsage(t *testing.T) {
+	if code := rollbackCommand([]string{}); code != 2 {
+		t.Errorf("Expected exit code 2 without repository, got %d", code)
+	}
+}
+
+func TestRollbackCommandUnknownRepo(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "repos:\n  app:\n    folders: [\"/srv/app\"]\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if code := rollbackCommand([]string{"-config", configPath, "other"}); code != 1 {
+		t.Errorf("Expected exit code 1 for unknown repository, got %d", code)
+	}
+	if code := rollbackCommand([]string{"-config", configPath, "-folder", "/srv/other", "app"}); code != 1 {
+		t.Errorf("Expected exit code 1 for unknown folder, got %d", code)
+	}
+	if code := rollbackCommand([]string{"-config", configPath, "-list", "app"}); code != 0 {
+		t.Errorf("Expected exit code 0 for empty history, got %d", code)
+	}
+}